@@ -0,0 +1,134 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// errHostNotAllowed is reported for requests to hosts that
+// ALLOWED_DESTINATION_HOSTS doesn't list. They are not retried.
+var errHostNotAllowed = errors.New("host is not in ALLOWED_DESTINATION_HOSTS")
+
+// hostAllowed reports whether host is listed in allowed, exactly or, for
+// entries such as "*.example.com", as a subdomain. An empty list allows
+// every host.
+func hostAllowed(allowed []string, host string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if suffix, ok := strings.CutPrefix(a, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == a {
+			return true
+		}
+	}
+	return false
+}
+
+// destinationURLs reports the URLs that the configured destinations are
+// reached at, by the setting that configures them.
+func destinationURLs(c *Config) map[string]string {
+	urls := map[string]string{
+		"TEAMS_WEBHOOK_URL":   c.Teams.WebhookURL,
+		"DISCORD_WEBHOOK_URL": c.Discord.WebhookURL,
+		"SLACK_WEBHOOK_URL":   c.Slack.WebhookURL,
+		"GENERIC_WEBHOOK_URL": c.Generic.URL,
+		"SQS_QUEUE_URL":       c.SQS.QueueURL,
+		"SIGNAL_API_URL":      c.Signal.APIURL,
+		"MASTODON_URL":        c.Mastodon.URL,
+		"FORWARD_URL":         c.Forward.URL,
+		"ON_SUCCESS_URL":      c.Callback.SuccessURL,
+		"ON_ERROR_URL":        c.Callback.ErrorURL,
+	}
+	// Destinations with a fixed API.
+	if c.Discord.WebhookURL == "" && c.Discord.BotToken != "" {
+		urls["DISCORD_BOT_TOKEN"] = discordAPIBaseURL
+	}
+	if c.Slack.WebhookURL == "" && c.Slack.BotToken != "" {
+		urls["SLACK_BOT_TOKEN"] = slackAPIBaseURL
+	}
+	if c.Line.Token != "" {
+		urls["LINE_CHANNEL_ACCESS_TOKEN"] = linePushURL
+	}
+	if c.TSAPIKey != "" {
+		urls["TS_API_KEY"] = tailscaleAPIBaseURL
+	}
+	if c.PubSub.Project != "" && c.PubSub.Topic != "" {
+		// Pub/Sub is reached over gRPC rather than through httpClient,
+		// at an endpoint that only the emulator setting changes.
+		if host := os.Getenv("PUBSUB_EMULATOR_HOST"); host != "" {
+			urls["PUBSUB_EMULATOR_HOST"] = "http://" + host
+		} else {
+			urls["PUBSUB_PROJECT"] = pubsubEndpoint
+		}
+	}
+	return urls
+}
+
+// checkDestinationHosts reports the destinations configured in c whose
+// hosts ALLOWED_DESTINATION_HOSTS doesn't allow. As the URLs may hold
+// credentials, errors only name their hosts.
+func checkDestinationHosts(c *Config) error {
+	if len(c.AllowedHosts) == 0 {
+		return nil
+	}
+	urls := destinationURLs(c)
+	var errs []error
+	for _, setting := range sortedKeys(urls) {
+		if urls[setting] == "" {
+			continue
+		}
+		u, err := url.Parse(urls[setting])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid URL", setting))
+			continue
+		}
+		if !hostAllowed(c.AllowedHosts, u.Hostname()) {
+			errs = append(errs, fmt.Errorf("%s: host %q is not allowed", setting, u.Hostname()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// allowedHostsTransport refuses requests, including redirects, to hosts
+// that ALLOWED_DESTINATION_HOSTS doesn't allow, in case a URL slips past
+// the check at startup.
+type allowedHostsTransport struct {
+	allowed []string
+	base    http.RoundTripper
+}
+
+func (t allowedHostsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !hostAllowed(t.allowed, req.URL.Hostname()) {
+		log.Printf("WARNING: refusing request to %s, which is not in ALLOWED_DESTINATION_HOSTS", req.URL.Hostname())
+		return nil, fmt.Errorf("%s: %w", req.URL.Hostname(), errHostNotAllowed)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// restrictOutboundHosts applies ALLOWED_DESTINATION_HOSTS to the shared
+// outbound transport. It must be called after configureOutboundTLS.
+func restrictOutboundHosts(allowed []string) {
+	if len(allowed) == 0 {
+		return
+	}
+	base := httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	httpClient.Transport = allowedHostsTransport{allowed: allowed, base: base}
+}
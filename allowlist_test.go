@@ -0,0 +1,79 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHostAllowed(t *testing.T) {
+	allowed := []string{"discord.com", "*.webhook.office.com"}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"discord.com", true},
+		{"Discord.com.", true},
+		{"evil-discord.com", false},
+		{"example.webhook.office.com", true},
+		{"webhook.office.com", false},
+		{"169.254.169.254", false},
+	}
+	for _, tt := range tests {
+		if got := hostAllowed(allowed, tt.host); got != tt.want {
+			t.Errorf("hostAllowed(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+	if !hostAllowed(nil, "anything.example") {
+		t.Error("an empty list does not allow every host")
+	}
+}
+
+func TestCheckDestinationHosts(t *testing.T) {
+	t.Setenv("PUBSUB_EMULATOR_HOST", "")
+	c := setTestConfig(t, map[string]string{
+		"SLACK_WEBHOOK_URL": "https://hooks.slack.com/services/x",
+		"SQS_QUEUE_URL":     "https://sqs.us-east-1.amazonaws.com/123456789012/events",
+		"PUBSUB_PROJECT":    "project",
+		"PUBSUB_TOPIC":      "topic",
+	})
+	c.AllowedHosts = []string{"hooks.slack.com"}
+	err := checkDestinationHosts(c)
+	for _, setting := range []string{"SQS_QUEUE_URL", "PUBSUB_PROJECT"} {
+		if err == nil || !strings.Contains(err.Error(), setting) {
+			t.Errorf("checkDestinationHosts = %v, want an error for %s", err, setting)
+		}
+	}
+	if err != nil && strings.Contains(err.Error(), "SLACK") {
+		t.Errorf("checkDestinationHosts = %v, want SLACK_WEBHOOK_URL allowed", err)
+	}
+
+	c.AllowedHosts = append(c.AllowedHosts, "sqs.us-east-1.amazonaws.com", "pubsub.googleapis.com")
+	if err := checkDestinationHosts(c); err != nil {
+		t.Errorf("checkDestinationHosts with every host allowed = %v", err)
+	}
+}
+
+func TestSQSClientIsRestricted(t *testing.T) {
+	old := httpClient.Transport
+	t.Cleanup(func() { httpClient.Transport = old })
+	restrictOutboundHosts([]string{"hooks.slack.com"})
+
+	setTestAWSEnv(t)
+	client, err := getSQSClient()
+	if err != nil {
+		t.Fatalf("getSQSClient: %v", err)
+	}
+	if client.Options().HTTPClient != httpClient {
+		t.Fatal("the SQS client does not use httpClient")
+	}
+	req, _ := http.NewRequest(http.MethodPost, "https://sqs.us-east-1.amazonaws.com/", nil)
+	if _, err := httpClient.Do(req); !errors.Is(err, errHostNotAllowed) {
+		t.Errorf("request to a host that is not allowed: %v, want errHostNotAllowed", err)
+	}
+}
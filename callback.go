@@ -0,0 +1,61 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// deliveryCallback is the JSON ping sent to ON_SUCCESS_URL or ON_ERROR_URL.
+type deliveryCallback struct {
+	Time        time.Time `json:"time"`
+	Destination string    `json:"destination"`
+	Status      string    `json:"status"` // "ok" or "error"
+	Error       string    `json:"error,omitempty"`
+}
+
+// notifyCallback pings ON_SUCCESS_URL or ON_ERROR_URL with the outcome of a
+// delivery. Callbacks are best-effort: they are sent in the background,
+// are not retried, and failures are only logged.
+func notifyCallback(c CallbackConfig, r DeliveryResult) {
+	cb := deliveryCallback{Time: time.Now().UTC(), Destination: r.Destination, Status: "ok"}
+	u := c.SuccessURL
+	if !r.Success {
+		cb.Status, cb.Error = "error", r.Err.Error()
+		u = c.ErrorURL
+	}
+	if u == "" {
+		// not configured
+		return
+	}
+	body, mErr := json.Marshal(cb)
+	if mErr != nil {
+		log.Printf("notifyCallback json.Marshal failed: %v", mErr)
+		return
+	}
+
+	go func() {
+		req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("notifyCallback %s failed: %v", cb.Status, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent())
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			log.Printf("notifyCallback %s failed: %v", cb.Status, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			log.Printf("notifyCallback %s failed: unexpected status %s", cb.Status, resp.Status)
+		}
+	}()
+}
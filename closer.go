@@ -0,0 +1,46 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "log"
+
+// closer is implemented by the parts of the adapter that hold events or
+// buffer data, which must be flushed on shutdown so that nothing is lost.
+type closer interface {
+	Close() error
+}
+
+// closerFunc adapts a function to the closer interface.
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+type namedCloser struct {
+	name string
+	closer
+}
+
+// closers reports what closeAll closes, in order: events held back are
+// delivered first, then the queue is drained, and then the destinations
+// that buffer are flushed. A destination that buffers adds itself here.
+func closers() []namedCloser {
+	return []namedCloser{
+		{"debounce", debounce},
+		{"digest", digest},
+		{"queue", queue},
+		{"pubsub", closerFunc(closePubSub)},
+		{"file", closerFunc(closeEventLog)},
+	}
+}
+
+// closeAll flushes and closes everything in closers, on shutdown or at
+// the end of a replay.
+func closeAll() {
+	for _, c := range closers() {
+		if err := c.Close(); err != nil {
+			log.Printf("closeAll %s failed: %v", c.name, err)
+		}
+	}
+}
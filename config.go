@@ -0,0 +1,879 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the adapter's resolved configuration. Every setting is named
+// after its environment variable, and is resolved from, in increasing
+// order of precedence:
+//
+//   - the built-in default
+//   - the JSON config file named by -config or CONFIG_FILE
+//   - the environment variable
+//   - the command-line flag, e.g. -teams-webhook-url for TEAMS_WEBHOOK_URL
+type Config struct {
+	Port               string
+	TLS                ServerTLSConfig
+	Server             ServerConfig
+	WebhookSecret      string            // TS_WEBHOOK_SECRET
+	TailnetSecrets     map[string]string // by tailnet
+	SignatureMode      string
+	SignatureHeaders   []string
+	SignatureAlgo      string
+	MaxEventsPerBatch  int
+	MaxEventsPolicy    string
+	UnknownTypePolicy  string
+	MaxInflightBatches int
+	StrictSchema       bool
+	MaxMessages        int // MAX_MESSAGES_PER_MINUTE, per destination
+	RequireDestination bool
+	PublicBaseURL      string
+	TrustProxy         bool
+	HealthWindow       time.Duration
+	StartupDelay       time.Duration
+	HTTPTimeout        time.Duration
+	Timeouts           map[string]time.Duration // by destination, from <DEST>_TIMEOUT
+	Gzip               map[string]bool          // by destination, from <DEST>_GZIP
+	GzipMinBytes       int
+	Debug              bool // LOG_LEVEL=debug
+	DryRun             bool
+	UserAgent          string // HTTP_USER_AGENT
+	IdempotencyHeader  string
+	InstanceName       string
+	TestToken          string
+	TestRateLimit      time.Duration
+	Mutes              map[string]time.Duration // MUTE_TYPES, from startup
+	MuteToken          string
+	UIToken            string
+	UIEvents           int // UI_EVENTS, the events /ui shows
+	Environment        string
+
+	DisplayLocation   *time.Location // DISPLAY_TZ
+	MinSeverity       severity
+	Severities        map[string]severity // by event type, from SEVERITY_MAP_FILE
+	QuietHours        *quietWindow
+	Digest            DigestConfig
+	Debounce          DebounceConfig
+	Resolve           ResolveConfig
+	Transform         TransformConfig
+	TSAPIKey          string
+	IncludeFields     []string
+	DropFields        []string
+	RedactFields      []string
+	Locale            string
+	TypeLabels        map[string]string
+	DefaultThemeColor string
+	IncludeRawJSON    bool
+	PrettyJSON        bool
+	SplitMessages     bool // SPLIT_LONG_MESSAGES
+	SplitMaxParts     int
+	FormatValues      bool
+	BooleanStyle      string // BOOLEAN_STYLE, for FORMAT_VALUES
+	Admin             AdminConfig
+	Profiles          map[string]string // by destination, from <DEST>_FORMAT_PROFILE
+
+	DeliveryMode   string
+	Queue          QueueConfig
+	Retry          RetryConfig
+	DeadLetterFile string
+	Outbound       OutboundConfig
+	AllowedHosts   []string // ALLOWED_DESTINATION_HOSTS
+
+	Teams    TeamsConfig
+	Discord  DiscordConfig
+	Slack    SlackConfig
+	Generic  GenericConfig
+	SQS      SQSConfig
+	Signal   SignalConfig
+	PubSub   PubSubConfig
+	Line     LineConfig
+	Mastodon MastodonConfig
+	EventLog EventLogConfig
+	Forward  ForwardConfig
+	Callback CallbackConfig
+	Stdout   StdoutConfig
+}
+
+// DigestConfig holds the DIGEST_* settings.
+type DigestConfig struct {
+	Interval    time.Duration
+	At          time.Duration // since midnight, if Interval is 0
+	MaxSeverity severity
+	Location    *time.Location // QUIET_HOURS_TZ, or DISPLAY_TZ
+}
+
+// Enabled reports whether DIGEST_INTERVAL or DIGEST_AT is set.
+func (c DigestConfig) Enabled() bool {
+	return c.Interval > 0 || c.At >= 0
+}
+
+// DebounceConfig holds the DEBOUNCE_* settings.
+type DebounceConfig struct {
+	Window time.Duration
+	Types  []string
+}
+
+// ResolveConfig holds the RESOLVE_* settings.
+type ResolveConfig struct {
+	Enabled bool                // RESOLVE_MESSAGES
+	Types   map[string][]string // resolved types, by resolving type
+	Window  time.Duration
+}
+
+// AdminConfig holds the ADMIN_* link templates.
+type AdminConfig struct {
+	BaseURL    string
+	DevicePath string
+	UserPath   string
+	PolicyPath string
+	Customized bool // any of them is set
+}
+
+// QueueConfig holds the QUEUE_* settings.
+type QueueConfig struct {
+	Mode       string
+	Size       int // per queue
+	Workers    int // for queueModeShared
+	FullPolicy string
+}
+
+// RetryConfig holds the retry settings shared by HTTP destinations.
+type RetryConfig struct {
+	MaxAttempts   int
+	MaxRetryAfter time.Duration
+	MaxElapsed    time.Duration
+	BackoffBase   time.Duration
+	BackoffMax    time.Duration
+	Statuses      map[string][]int // by destination, from <DEST>_RETRY_STATUSES
+	Granularity   string           // RETRY_GRANULARITY
+}
+
+// StatusesFor reports the response statuses that are retried for dest.
+func (c RetryConfig) StatusesFor(dest string) []int {
+	if s, ok := c.Statuses[dest]; ok {
+		return s
+	}
+	return c.Statuses[""]
+}
+
+// ServerTLSConfig holds the TLS_* settings of the server.
+type ServerTLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	MinVersion   uint16
+	CipherSuites []uint16
+}
+
+// ServerConfig holds the SERVER_*_TIMEOUT settings, which keep slow or
+// idle clients from tying up connections.
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+}
+
+// OutboundConfig holds the OUTBOUND_* TLS settings.
+type OutboundConfig struct {
+	CAFile             string
+	InsecureSkipVerify bool
+}
+
+// TeamsConfig holds the TEAMS_* settings.
+type TeamsConfig struct {
+	WebhookURL       string
+	RunbookURL       string
+	MaxFacts         int
+	CardTemplateFile string
+	IncludeTailnet   bool
+}
+
+// DiscordConfig holds the DISCORD_* settings.
+type DiscordConfig struct {
+	WebhookURL         string
+	BotToken           string
+	ChannelID          string
+	GuildID            string
+	ThreadNameTemplate string
+	MaxFields          int
+	MaxLength          int
+	LinkButtons        bool
+	IncludeTailnet     bool // in thread names and titles
+}
+
+// SlackConfig holds the SLACK_* settings.
+type SlackConfig struct {
+	WebhookURL     string
+	BotToken       string
+	Channel        string
+	ChannelMap     map[string]string // by event type
+	ThreadKey      string            // a placeholder template, e.g. "{device}"
+	ThreadWindow   time.Duration
+	MaxLength      int
+	IncludeTailnet bool
+}
+
+// GenericConfig holds the GENERIC_WEBHOOK_* settings.
+type GenericConfig struct {
+	URL          string
+	TemplateFile string
+	Headers      http.Header
+}
+
+// SQSConfig holds the SQS_* settings.
+type SQSConfig struct {
+	QueueURL string
+}
+
+// SignalConfig holds the SIGNAL_* settings.
+type SignalConfig struct {
+	APIURL     string
+	Number     string
+	Recipients []string
+	MaxLength  int
+}
+
+// PubSubConfig holds the PUBSUB_* settings.
+type PubSubConfig struct {
+	Project string
+	Topic   string
+}
+
+// LineConfig holds the LINE_* settings.
+type LineConfig struct {
+	Token     string // LINE_CHANNEL_ACCESS_TOKEN
+	To        string // the ID of a user, group or room
+	MaxLength int
+}
+
+// MastodonConfig holds the MASTODON_* settings.
+type MastodonConfig struct {
+	URL        string
+	Token      string
+	Visibility string
+	MaxLength  int
+}
+
+// EventLogConfig holds the EVENT_LOG_* settings.
+type EventLogConfig struct {
+	File         string
+	SyncInterval time.Duration
+	MaxBytes     int64
+}
+
+// ForwardConfig holds the FORWARD_* settings.
+type ForwardConfig struct {
+	URL     string
+	Secret  string // defaults to TS_WEBHOOK_SECRET
+	MaxHops int
+}
+
+// CallbackConfig holds the ON_SUCCESS_URL and ON_ERROR_URL settings.
+type CallbackConfig struct {
+	SuccessURL string
+	ErrorURL   string
+}
+
+// StdoutConfig holds the STDOUT_EVENTS setting.
+type StdoutConfig struct {
+	Enabled bool
+}
+
+// cfg is the configuration in use, set by main.
+var cfg = new(Config)
+
+// httpDestinations are the destinations that accept <DEST>_RETRY_STATUSES,
+// <DEST>_TIMEOUT and <DEST>_GZIP.
+var httpDestinations = []string{"teams", "discord", "slack", "generic", "signal", "line", "mastodon", "forward"}
+
+// newConfig resolves the configuration from lookup, which reports the raw
+// value of a setting by name. Invalid values are reported together.
+//
+// Every setting must be read unconditionally, so that settingNames can
+// discover them.
+func newConfig(lookup func(name string) (string, bool)) (*Config, error) {
+	l := &configLoader{lookup: lookup}
+	c := &Config{
+		Port:               l.str("PORT", "8080"),
+		WebhookSecret:      l.str("TS_WEBHOOK_SECRET", ""),
+		TailnetSecrets:     l.dict("TAILNET_SECRETS"),
+		SignatureHeaders:   l.list("SIGNATURE_HEADER", []string{defaultSignatureHeader}),
+		SignatureAlgo:      l.oneOf("SIGNATURE_ALGO", "sha256", "sha256", "sha512"),
+		MaxEventsPerBatch:  l.integer("MAX_EVENTS_PER_BATCH", 1000),
+		MaxEventsPolicy:    l.oneOf("MAX_EVENTS_POLICY", "drop", "drop", "reject"),
+		UnknownTypePolicy:  l.oneOf("UNKNOWN_TYPE_POLICY", "forward", "forward", "drop", "forward_tagged"),
+		MaxInflightBatches: l.integer("MAX_INFLIGHT_BATCHES", 0),
+		StrictSchema:       l.boolean("STRICT_SCHEMA", false),
+		MaxMessages:        l.integer("MAX_MESSAGES_PER_MINUTE", 0),
+		RequireDestination: l.boolean("REQUIRE_DESTINATION", false),
+		PublicBaseURL:      l.str("PUBLIC_BASE_URL", ""),
+		TrustProxy:         l.boolean("TRUST_PROXY", false),
+		HealthWindow:       l.duration("HEALTH_WINDOW", 10*time.Minute),
+		StartupDelay:       l.duration("STARTUP_DELAY", 0),
+		HTTPTimeout:        l.duration("HTTP_TIMEOUT", 10*time.Second),
+		Timeouts:           map[string]time.Duration{},
+		Gzip:               map[string]bool{},
+		GzipMinBytes:       l.integer("GZIP_MIN_BYTES", 1024),
+		Debug:              strings.EqualFold(l.str("LOG_LEVEL", ""), "debug"),
+		DryRun:             l.boolean("DRY_RUN", false),
+		UserAgent:          l.str("HTTP_USER_AGENT", ""),
+		IdempotencyHeader:  l.str("IDEMPOTENCY_HEADER", "Idempotency-Key"),
+		InstanceName:       l.str("INSTANCE_NAME", ""),
+		TestToken:          l.str("TEST_TOKEN", ""),
+		TestRateLimit:      l.duration("TEST_RATE_LIMIT", 10*time.Second),
+		MuteToken:          l.str("MUTE_TOKEN", ""),
+		UIToken:            l.str("UI_TOKEN", ""),
+		UIEvents:           l.integer("UI_EVENTS", 100),
+		Environment:        l.str("ENVIRONMENT", ""),
+
+		Debounce: DebounceConfig{
+			Window: l.duration("DEBOUNCE_WINDOW", 0),
+			Types:  l.list("DEBOUNCE_TYPES", defaultDebounceTypes),
+		},
+		Transform: TransformConfig{
+			Command:  strings.Fields(l.str("TRANSFORM_CMD", "")),
+			Timeout:  l.duration("TRANSFORM_TIMEOUT", 2*time.Second),
+			MaxBytes: l.integer("TRANSFORM_MAX_BYTES", 1<<20),
+		},
+		Resolve: ResolveConfig{
+			Enabled: l.boolean("RESOLVE_MESSAGES", false),
+			Types:   parseResolveTypes(l.dict("RESOLVE_TYPES")),
+			Window:  l.duration("RESOLVE_WINDOW", 7*24*time.Hour),
+		},
+		TSAPIKey:       l.str("TS_API_KEY", ""),
+		IncludeFields:  l.list("INCLUDE_FIELDS", nil),
+		DropFields:     l.list("DROP_FIELDS", nil),
+		RedactFields:   l.list("REDACT_FIELDS", nil),
+		TypeLabels:     l.dict("TYPE_LABELS"),
+		IncludeRawJSON: l.boolean("INCLUDE_RAW_JSON", false),
+		PrettyJSON:     l.boolean("PRETTY_JSON", false),
+		SplitMessages:  l.boolean("SPLIT_LONG_MESSAGES", false),
+		SplitMaxParts:  l.integer("SPLIT_MAX_PARTS", 10),
+		FormatValues:   l.boolean("FORMAT_VALUES", false),
+		BooleanStyle:   l.oneOf("BOOLEAN_STYLE", "words", "words", "symbols"),
+		Profiles:       map[string]string{},
+
+		Retry: RetryConfig{
+			MaxAttempts:   l.integer("RETRY_MAX_ATTEMPTS", 4),
+			MaxRetryAfter: l.duration("MAX_RETRY_AFTER", time.Minute),
+			MaxElapsed:    l.duration("MAX_RETRY_ELAPSED", 2*time.Minute),
+			BackoffBase:   l.duration("RETRY_BACKOFF_BASE", 500*time.Millisecond),
+			BackoffMax:    l.duration("RETRY_BACKOFF_MAX", 30*time.Second),
+			Statuses:      map[string][]int{},
+			Granularity:   l.oneOf("RETRY_GRANULARITY", retryGranularityEvent, retryGranularityEvent, retryGranularityBatch),
+		},
+		Server: ServerConfig{
+			ReadHeaderTimeout: l.duration("SERVER_READ_HEADER_TIMEOUT", 10*time.Second),
+			ReadTimeout:       l.duration("SERVER_READ_TIMEOUT", 30*time.Second),
+			WriteTimeout:      l.duration("SERVER_WRITE_TIMEOUT", 5*time.Minute),
+			IdleTimeout:       l.duration("SERVER_IDLE_TIMEOUT", 2*time.Minute),
+		},
+		Queue: QueueConfig{
+			Mode:       l.oneOf("QUEUE_MODE", "", "", queueModeShared, queueModePerDestination, queueModePerTailnet),
+			Size:       l.integer("QUEUE_SIZE", 1000),
+			Workers:    l.integer("QUEUE_WORKERS", 4),
+			FullPolicy: l.oneOf("QUEUE_FULL_POLICY", queueFullBlock, queueFullBlock, queueFullDropOldest, queueFullDropNew, queueFullReject),
+		},
+		DeadLetterFile: l.str("DEAD_LETTER_FILE", ""),
+		AllowedHosts:   l.list("ALLOWED_DESTINATION_HOSTS", nil),
+		Outbound: OutboundConfig{
+			CAFile:             l.str("OUTBOUND_CA_FILE", ""),
+			InsecureSkipVerify: l.boolean("OUTBOUND_INSECURE_SKIP_VERIFY", false),
+		},
+
+		Teams: TeamsConfig{
+			WebhookURL:       l.str("TEAMS_WEBHOOK_URL", ""),
+			RunbookURL:       l.str("TEAMS_RUNBOOK_URL", ""),
+			MaxFacts:         l.integer("TEAMS_MAX_FACTS", 20),
+			CardTemplateFile: l.str("TEAMS_CARD_TEMPLATE_FILE", ""),
+		},
+		Discord: DiscordConfig{
+			WebhookURL:         l.str("DISCORD_WEBHOOK_URL", ""),
+			BotToken:           l.str("DISCORD_BOT_TOKEN", ""),
+			ChannelID:          l.str("DISCORD_CHANNEL_ID", ""),
+			GuildID:            l.str("DISCORD_GUILD_ID", ""),
+			ThreadNameTemplate: l.str("DISCORD_THREAD_NAME_TEMPLATE", ""),
+			MaxFields:          l.integer("DISCORD_MAX_FIELDS", 25),
+			LinkButtons:        l.boolean("DISCORD_LINK_BUTTONS", true),
+		},
+		Slack: SlackConfig{
+			WebhookURL:   l.str("SLACK_WEBHOOK_URL", ""),
+			BotToken:     l.str("SLACK_BOT_TOKEN", ""),
+			Channel:      l.str("SLACK_CHANNEL", ""),
+			ChannelMap:   l.dict("SLACK_CHANNEL_MAP"),
+			ThreadKey:    l.str("SLACK_THREAD_KEY", ""),
+			ThreadWindow: l.duration("SLACK_THREAD_WINDOW", 24*time.Hour),
+		},
+		Generic: GenericConfig{
+			URL:          l.str("GENERIC_WEBHOOK_URL", ""),
+			TemplateFile: l.str("GENERIC_WEBHOOK_TEMPLATE_FILE", ""),
+		},
+		SQS: SQSConfig{
+			QueueURL: l.str("SQS_QUEUE_URL", ""),
+		},
+		Signal: SignalConfig{
+			APIURL:     l.str("SIGNAL_API_URL", ""),
+			Number:     l.str("SIGNAL_NUMBER", ""),
+			Recipients: l.list("SIGNAL_RECIPIENTS", nil),
+		},
+		PubSub: PubSubConfig{
+			Project: l.str("PUBSUB_PROJECT", ""),
+			Topic:   l.str("PUBSUB_TOPIC", ""),
+		},
+		Line: LineConfig{
+			Token: l.str("LINE_CHANNEL_ACCESS_TOKEN", ""),
+			To:    l.str("LINE_TO", ""),
+		},
+		Mastodon: MastodonConfig{
+			URL:        l.str("MASTODON_URL", ""),
+			Token:      l.str("MASTODON_TOKEN", ""),
+			Visibility: l.oneOf("MASTODON_VISIBILITY", "unlisted", "public", "unlisted", "private", "direct"),
+		},
+		EventLog: EventLogConfig{
+			File:         l.str("EVENT_LOG_FILE", ""),
+			SyncInterval: l.duration("EVENT_LOG_SYNC_INTERVAL", 5*time.Second),
+			MaxBytes:     int64(l.integer("EVENT_LOG_MAX_BYTES", 0)),
+		},
+		Forward: ForwardConfig{
+			URL:     l.str("FORWARD_URL", ""),
+			MaxHops: l.integer("FORWARD_MAX_HOPS", 1),
+		},
+		Callback: CallbackConfig{
+			SuccessURL: l.str("ON_SUCCESS_URL", ""),
+			ErrorURL:   l.str("ON_ERROR_URL", ""),
+		},
+		Stdout: StdoutConfig{
+			Enabled: l.boolean("STDOUT_EVENTS", false),
+		},
+	}
+
+	var err error
+	c.SignatureMode, err = parseSignatureMode(l.str("SIGNATURE_MODE", ""))
+	l.check("SIGNATURE_MODE", err)
+	c.MinSeverity, err = parseSeverity(l.str("MIN_SEVERITY", ""))
+	l.check("MIN_SEVERITY", err)
+	c.Severities, err = readSeverityMap(l.str("SEVERITY_MAP_FILE", ""))
+	l.check("SEVERITY_MAP_FILE", err)
+	c.Mutes, err = parseMutes(l.dict("MUTE_TYPES"))
+	l.check("MUTE_TYPES", err)
+	c.Locale, err = parseLocale(l.str("LOCALE", ""))
+	l.check("LOCALE", err)
+	c.DefaultThemeColor, err = parseThemeColor(l.str("DEFAULT_THEME_COLOR", defaultThemeColor))
+	l.check("DEFAULT_THEME_COLOR", err)
+	// DELIVERY_MODE defaults to async when a QUEUE_MODE is set, which
+	// was all there was to it before DELIVERY_MODE.
+	c.DeliveryMode = l.oneOf("DELIVERY_MODE", "", "", deliveryModeSync, deliveryModeAsync)
+	switch {
+	case c.DeliveryMode == "" && c.Queue.Mode != "":
+		c.DeliveryMode = deliveryModeAsync
+	case c.DeliveryMode == "":
+		c.DeliveryMode = deliveryModeSync
+	case c.DeliveryMode == deliveryModeAsync && c.Queue.Mode == "":
+		c.Queue.Mode = queueModeShared
+	case c.DeliveryMode == deliveryModeSync && c.Queue.Mode != "":
+		l.check("QUEUE_MODE", errors.New("requires DELIVERY_MODE=async"))
+	}
+	if c.Retry.Granularity == retryGranularityBatch && c.DeliveryMode != deliveryModeSync {
+		l.check("RETRY_GRANULARITY", errors.New("batch requires DELIVERY_MODE=sync"))
+	}
+	if c.Queue.Size < 0 {
+		l.check("QUEUE_SIZE", errors.New("must not be negative"))
+	}
+	if c.Queue.Workers < 1 {
+		l.check("QUEUE_WORKERS", errors.New("must be at least 1"))
+	}
+	c.TLS.CertFile = l.str("TLS_CERT_FILE", "")
+	c.TLS.KeyFile = l.str("TLS_KEY_FILE", "")
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		l.check("TLS_CERT_FILE", errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	c.TLS.MinVersion, err = parseTLSVersion(l.str("TLS_MIN_VERSION", "1.2"))
+	l.check("TLS_MIN_VERSION", err)
+	c.TLS.CipherSuites, err = parseCipherSuites(l.list("TLS_CIPHER_SUITES", nil))
+	l.check("TLS_CIPHER_SUITES", err)
+	l.check("GENERIC_WEBHOOK_TEMPLATE_FILE", checkPayloadTemplate(c.Generic.TemplateFile, sampleEvent))
+	l.check("TEAMS_CARD_TEMPLATE_FILE", checkPayloadTemplate(c.Teams.CardTemplateFile, teamsCardData{
+		incomingWebhook: sampleEvent,
+		Label:           sampleEvent.Type,
+		Severity:        "info",
+		Color:           defaultThemeColor,
+		AdminURL:        defaultAdminBaseURL + defaultAdminDevicePath,
+	}))
+	c.Generic.Headers, err = parseHeaders(l.str("GENERIC_WEBHOOK_HEADERS", ""))
+	l.check("GENERIC_WEBHOOK_HEADERS", err)
+	l.check("NOTIFY_URLS", applyNotifyURLs(c, l.list("NOTIFY_URLS", nil)))
+
+	c.DisplayLocation = time.UTC
+	displayTZ := l.str("DISPLAY_TZ", "")
+	if displayTZ != "" {
+		c.DisplayLocation, err = time.LoadLocation(displayTZ)
+		l.check("DISPLAY_TZ", err)
+	}
+	tz := l.str("QUIET_HOURS_TZ", displayTZ)
+	c.QuietHours, err = parseQuietHours(l.str("QUIET_HOURS", ""), tz, l.str("QUIET_HOURS_MIN_SEVERITY", ""))
+	l.check("QUIET_HOURS", err)
+
+	c.Digest = DigestConfig{
+		Interval: l.duration("DIGEST_INTERVAL", 0),
+		At:       -1,
+		Location: time.Local,
+	}
+	if at := l.str("DIGEST_AT", ""); at != "" && c.Digest.Interval == 0 {
+		c.Digest.At, err = parseClock(at)
+		l.check("DIGEST_AT", err)
+	} else if c.Digest.Interval < 0 {
+		l.check("DIGEST_INTERVAL", errors.New("must be positive"))
+	}
+	c.Digest.MaxSeverity, err = parseSeverity(l.str("DIGEST_MAX_SEVERITY", ""))
+	l.check("DIGEST_MAX_SEVERITY", err)
+	if tz != "" {
+		c.Digest.Location, err = time.LoadLocation(tz)
+		l.check("QUIET_HOURS_TZ", err)
+	}
+
+	admin := func(name, def string) string {
+		v := l.str(name, "")
+		if v == "" {
+			return def
+		}
+		c.Admin.Customized = true
+		return v
+	}
+	c.Admin.BaseURL = strings.TrimSuffix(admin("ADMIN_BASE_URL", defaultAdminBaseURL), "/")
+	c.Admin.DevicePath = admin("ADMIN_DEVICE_PATH", defaultAdminDevicePath)
+	c.Admin.UserPath = admin("ADMIN_USER_PATH", defaultAdminUserPath)
+	c.Admin.PolicyPath = admin("ADMIN_POLICY_PATH", defaultAdminPolicyPath)
+
+	c.Retry.Statuses[""] = l.statuses("RETRY_STATUSES", defaultRetryStatuses)
+	for _, dest := range httpDestinations {
+		prefix := strings.ToUpper(dest) + "_"
+		if s := l.statuses(prefix+"RETRY_STATUSES", nil); s != nil {
+			c.Retry.Statuses[dest] = s
+		}
+		if t := l.duration(prefix+"TIMEOUT", 0); t > 0 {
+			c.Timeouts[dest] = t
+		}
+		if l.boolean(prefix+"GZIP", false) {
+			c.Gzip[dest] = true
+		}
+	}
+	profile := l.oneOf("FORMAT_PROFILE", profileFull, formatProfiles...)
+	for _, dest := range profileDestinations {
+		c.Profiles[dest] = l.oneOf(strings.ToUpper(dest)+"_FORMAT_PROFILE", profile, formatProfiles...)
+	}
+	c.Forward.Secret = l.str("FORWARD_SECRET", c.WebhookSecret)
+	includeTailnet := l.boolean("INCLUDE_TAILNET", false)
+	c.Teams.IncludeTailnet = l.boolean("TEAMS_INCLUDE_TAILNET", includeTailnet)
+	c.Discord.IncludeTailnet = l.boolean("DISCORD_INCLUDE_TAILNET", includeTailnet)
+	c.Slack.IncludeTailnet = l.boolean("SLACK_INCLUDE_TAILNET", includeTailnet)
+	c.Discord.MaxLength = l.integer("DISCORD_MAX_LENGTH", discordContentLimit)
+	c.Signal.MaxLength = l.integer("SIGNAL_MAX_LENGTH", signalMessageLimit)
+	c.Line.MaxLength = l.integer("LINE_MAX_LENGTH", lineMessageLimit)
+	c.Slack.MaxLength = l.integer("SLACK_MAX_LENGTH", slackTextLimit)
+	if l.str("LINE_NOTIFY_TOKEN", "") != "" {
+		l.check("LINE_NOTIFY_TOKEN", errors.New("LINE Notify was shut down on 2025-03-31; set LINE_CHANNEL_ACCESS_TOKEN and LINE_TO to use the LINE Messaging API instead"))
+	}
+	c.Mastodon.MaxLength = l.integer("MASTODON_MAX_LENGTH", mastodonStatusLimit)
+	l.check("ALLOWED_DESTINATION_HOSTS", checkDestinationHosts(c))
+
+	return c, errors.Join(l.errs...)
+}
+
+// configLoader reads typed settings, collecting the errors of any that
+// are invalid.
+type configLoader struct {
+	lookup func(name string) (string, bool)
+	errs   []error
+}
+
+func (l *configLoader) check(name string, err error) {
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: %w", name, err))
+	}
+}
+
+func (l *configLoader) str(name, def string) string {
+	if v, ok := l.lookup(name); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func (l *configLoader) boolean(name string, def bool) bool {
+	v := l.str(name, "")
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	l.check(name, err)
+	return b
+}
+
+func (l *configLoader) integer(name string, def int) int {
+	v := l.str(name, "")
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	l.check(name, err)
+	return n
+}
+
+// duration parses a setting such as "30s" as a time.Duration.
+func (l *configLoader) duration(name string, def time.Duration) time.Duration {
+	v := l.str(name, "")
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	l.check(name, err)
+	return d
+}
+
+// oneOf reads a setting that must be one of the given values.
+func (l *configLoader) oneOf(name, def string, values ...string) string {
+	v := l.str(name, def)
+	if !slices.Contains(values, v) {
+		l.check(name, fmt.Errorf("%q is not one of %s", v, strings.Join(values, ", ")))
+		return def
+	}
+	return v
+}
+
+// list reads a comma-separated list, or a JSON array, of non-empty,
+// whitespace-trimmed elements.
+func (l *configLoader) list(name string, def []string) []string {
+	v := l.str(name, "")
+	if v == "" {
+		return def
+	}
+	if strings.HasPrefix(v, "[") {
+		var list []string
+		l.check(name, json.Unmarshal([]byte(v), &list))
+		return list
+	}
+	var list []string
+	for _, e := range strings.Split(v, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// dict reads a comma-separated list of key=value pairs, such as
+// "a=1,b=2", or a JSON object.
+func (l *configLoader) dict(name string) map[string]string {
+	m := make(map[string]string)
+	v := l.str(name, "")
+	if strings.HasPrefix(v, "{") {
+		l.check(name, json.Unmarshal([]byte(v), &m))
+		return m
+	}
+	for _, pair := range l.list(name, nil) {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			l.check(name, fmt.Errorf("%q is not of the form key=value", pair))
+			continue
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return m
+}
+
+// statuses reads a list of HTTP response statuses, e.g. "429,500,502".
+func (l *configLoader) statuses(name string, def []int) []int {
+	list := l.list(name, nil)
+	if len(list) == 0 {
+		return def
+	}
+	statuses := make([]int, 0, len(list))
+	for _, v := range list {
+		code, err := strconv.Atoi(v)
+		if err != nil || code < 100 || code > 599 {
+			l.check(name, fmt.Errorf("invalid status %q", v))
+			continue
+		}
+		statuses = append(statuses, code)
+	}
+	return statuses
+}
+
+// settingNames reports the names of all settings, in the order newConfig
+// reads them.
+func settingNames() []string {
+	var names []string
+	newConfig(func(name string) (string, bool) {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+		return "", false
+	})
+	return names
+}
+
+// flagName reports the command-line flag for a setting, e.g.
+// "teams-webhook-url" for TEAMS_WEBHOOK_URL.
+func flagName(setting string) string {
+	return strings.ReplaceAll(strings.ToLower(setting), "_", "-")
+}
+
+// loadConfigFile reads a JSON object of settings, keyed by the names of
+// their environment variables. Strings are used as is; other values, such
+// as numbers, booleans, arrays and objects, are used as their JSON text.
+// References to environment variables in strings, including those within
+// arrays and objects, are replaced with their values (see interpolateEnv),
+// so that secrets can be kept out of the file.
+func loadConfigFile(filename string) (map[string]string, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	known := settingNames()
+	settings := make(map[string]string, len(raw))
+	for name, v := range raw {
+		if !slices.Contains(known, name) {
+			return nil, fmt.Errorf("%s: unknown setting %q", filename, name)
+		}
+		var val any
+		dec := json.NewDecoder(bytes.NewReader(v))
+		dec.UseNumber() // keep numbers as written
+		if err := dec.Decode(&val); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", filename, name, err)
+		}
+		val, err := interpolateValue(val, os.LookupEnv)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", filename, name, err)
+		}
+		s, ok := val.(string)
+		if !ok {
+			b, err := json.Marshal(val)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", filename, name, err)
+			}
+			s = string(b)
+		}
+		settings[name] = s
+	}
+	return settings, nil
+}
+
+// interpolateValue replaces references to environment variables in the
+// strings of a decoded JSON value, keeping the structure intact.
+func interpolateValue(v any, lookup func(string) (string, bool)) (any, error) {
+	var err error
+	switch v := v.(type) {
+	case string:
+		return interpolateEnv(v, lookup)
+	case []any:
+		for i := range v {
+			if v[i], err = interpolateValue(v[i], lookup); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]any:
+		for k := range v {
+			if v[k], err = interpolateValue(v[k], lookup); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// interpolateEnv replaces ${VAR} in s with the value of the environment
+// variable VAR, reported by lookup, and ${VAR:-default} with the value of
+// VAR or, if VAR is unset or empty, default. It is an error for a variable
+// without a default to be unset. $${ is left as a literal ${.
+func interpolateEnv(s string, lookup func(string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", s[i:])
+		}
+		ref := s[i+2 : i+end]
+		s = s[i+end+1:]
+
+		name, def, hasDef := strings.Cut(ref, ":-")
+		if name == "" {
+			return "", errors.New("empty variable name in ${}")
+		}
+		v, ok := lookup(name)
+		switch {
+		case hasDef && v == "":
+			v = def
+		case !ok:
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		b.WriteString(v)
+	}
+}
+
+// loadConfig resolves the configuration from the config file, the
+// environment and the command-line arguments, and reports the remaining
+// arguments.
+func loadConfig(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("ts-webhook-adapter", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON `file` of settings, keyed by environment variable name")
+	flags := map[string]*string{}
+	for _, name := range settingNames() {
+		flags[name] = fs.String(flagName(name), "", "overrides "+name)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var file map[string]string
+	if *configFile != "" {
+		var err error
+		if file, err = loadConfigFile(*configFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	c, err := newConfig(func(name string) (string, bool) {
+		if set[flagName(name)] {
+			return *flags[name], true
+		}
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			return v, true
+		}
+		v, ok := file[name]
+		return v, ok
+	})
+	return c, fs.Args(), err
+}
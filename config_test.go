@@ -0,0 +1,172 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// setTestConfig loads the configuration from env, instead of the
+// environment, and makes it the one in use for the rest of the test.
+func setTestConfig(t testing.TB, env map[string]string) *Config {
+	t.Helper()
+	c, err := newConfig(func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatalf("newConfig: %v", err)
+	}
+	old := cfg
+	cfg = c
+	t.Cleanup(func() { cfg = old })
+	return c
+}
+
+func TestInterpolateEnv(t *testing.T) {
+	env := map[string]string{"HOST": "example.com", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"plain", "plain", false},
+		{"https://${HOST}/hook", "https://example.com/hook", false},
+		{"${HOST}${HOST}", "example.comexample.com", false},
+		{"${HOST:-other.com}", "example.com", false},
+		{"${UNSET:-fallback}", "fallback", false},
+		{"${EMPTY:-fallback}", "fallback", false},
+		{"${UNSET:-}", "", false},
+		{"${EMPTY}", "", false},
+		{"${UNSET}", "", true},
+		{"a ${HOST", "", true},
+		{"${}", "", true},
+		{"$${HOST}", "${HOST}", false},
+		{"cost: $5, $${NOT_A_VAR} and ${HOST}", "cost: $5, ${NOT_A_VAR} and example.com", false},
+	}
+	for _, tt := range tests {
+		got, err := interpolateEnv(tt.in, lookup)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("interpolateEnv(%q) = %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("interpolateEnv(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigFileInterpolates(t *testing.T) {
+	t.Setenv("TEST_TEAMS_HOST", "example.webhook.office.com")
+	path := filepath.Join(t.TempDir(), "config.json")
+	const file = `{
+		"TEAMS_WEBHOOK_URL": "https://${TEST_TEAMS_HOST}/hook",
+		"REDACT_FIELDS": ["actor", "${TEST_UNSET:-user}"],
+		"LOG_LEVEL": "$${literal}"
+	}`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"TEAMS_WEBHOOK_URL": "https://example.webhook.office.com/hook",
+		"REDACT_FIELDS":     `["actor","user"]`,
+		"LOG_LEVEL":         "${literal}",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("loadConfigFile = %v, want %v", got, want)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"TEAMS_WEBHOOK_URL": "${TEST_UNSET}"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfigFile(path); err == nil {
+		t.Error("loadConfigFile with an unset variable succeeded, want an error")
+	}
+}
+
+// TestConfigPrecedence sets one setting in each layer in turn, checking
+// that flags override the environment, which overrides the config file,
+// which overrides the default.
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"IDEMPOTENCY_HEADER": "X-From-File", "DISCORD_MAX_LENGTH": 1500}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		file       bool
+		env        string
+		flag       string
+		wantHeader string
+	}{
+		{"default", false, "", "", "Idempotency-Key"},
+		{"file over default", true, "", "", "X-From-File"},
+		{"environment over file", true, "X-From-Env", "", "X-From-Env"},
+		{"flag over environment", true, "X-From-Env", "X-From-Flag", "X-From-Flag"},
+		{"flag without file", false, "X-From-Env", "X-From-Flag", "X-From-Flag"},
+		{"empty environment falls through", true, "", "", "X-From-File"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", "")
+			t.Setenv("IDEMPOTENCY_HEADER", tt.env)
+			var args []string
+			if tt.file {
+				args = append(args, "-config", path)
+			}
+			if tt.flag != "" {
+				args = append(args, "-idempotency-header", tt.flag)
+			}
+			args = append(args, "rest")
+			c, rest, err := loadConfig(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.IdempotencyHeader != tt.wantHeader {
+				t.Errorf("IdempotencyHeader = %q, want %q", c.IdempotencyHeader, tt.wantHeader)
+			}
+			wantMax := discordContentLimit
+			if tt.file {
+				wantMax = 1500
+			}
+			if c.Discord.MaxLength != wantMax {
+				t.Errorf("Discord.MaxLength = %d, want %d", c.Discord.MaxLength, wantMax)
+			}
+			if !slices.Equal(rest, []string{"rest"}) {
+				t.Errorf("remaining arguments = %q, want [rest]", rest)
+			}
+		})
+	}
+}
+
+func TestConfigFileFromEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"IDEMPOTENCY_HEADER": "X-From-File"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("IDEMPOTENCY_HEADER", "")
+	c, _, err := loadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.IdempotencyHeader != "X-From-File" {
+		t.Errorf("IdempotencyHeader = %q, want the value from CONFIG_FILE", c.IdempotencyHeader)
+	}
+}
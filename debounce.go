@@ -0,0 +1,117 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultDebounceTypes are the event types debounced when DEBOUNCE_TYPES
+// is not set: key expiry events tend to arrive for many devices at once.
+var defaultDebounceTypes = []string{"nodeKeyExpiringInOneDay", "nodeKeyExpired"}
+
+// debouncer coalesces bursts of events of the same type, so that a burst
+// is delivered as one summary notification at the end of the window that
+// started with its first event.
+type debouncer struct {
+	mu      sync.Mutex
+	pending map[string][]incomingWebhook // by debounceKey
+}
+
+var debounce = &debouncer{pending: map[string][]incomingWebhook{}}
+
+func debounceKey(orig incomingWebhook) string {
+	return orig.Tailnet + "\x00" + orig.Type
+}
+
+// add holds the event back if DEBOUNCE_WINDOW is set and its type is
+// listed in DEBOUNCE_TYPES, reporting whether it did so.
+func (d *debouncer) add(orig incomingWebhook) bool {
+	window := cfg.Debounce.Window
+	if window <= 0 {
+		return false
+	}
+	if !slices.Contains(cfg.Debounce.Types, orig.Type) {
+		return false
+	}
+
+	key := debounceKey(orig)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.pending[key]; !ok {
+		time.AfterFunc(window, func() { d.flush(key) })
+	}
+	d.pending[key] = append(d.pending[key], orig)
+	return true
+}
+
+// flush delivers the events held back under key.
+func (d *debouncer) flush(key string) {
+	d.mu.Lock()
+	events := d.pending[key]
+	delete(d.pending, key)
+	d.mu.Unlock()
+
+	switch len(events) {
+	case 0:
+		return
+	case 1:
+		deliverEvent(events[0])
+	default:
+		deliverEvent(summarizeEvents(events))
+	}
+}
+
+// Close delivers all events held back, without waiting for their windows
+// to end, so that they aren't lost on shutdown.
+func (d *debouncer) Close() error {
+	d.mu.Lock()
+	keys := sortedKeys(d.pending)
+	d.mu.Unlock()
+	for _, key := range keys {
+		d.flush(key)
+	}
+	return nil
+}
+
+// summarizeEvents combines events of the same type into one, listing the
+// devices involved as far as their filtered data names them.
+func summarizeEvents(events []incomingWebhook) incomingWebhook {
+	first, last := events[0], events[len(events)-1]
+	var devices []string
+	for _, e := range events {
+		data := filterData(e.Data)
+		for _, k := range []string{"deviceName", "hostname", "nodeID"} {
+			if v := data[k]; v != "" && v != redactedValue {
+				if !slices.Contains(devices, v) {
+					devices = append(devices, v)
+				}
+				break
+			}
+		}
+	}
+
+	summary := incomingWebhook{
+		Timestamp: last.Timestamp,
+		Version:   last.Version,
+		Type:      first.Type,
+		Tailnet:   first.Tailnet,
+		Message:   fmt.Sprintf(tr("eventsSummary"), len(events), eventLabel(first.Type)),
+		Data: map[string]string{
+			"count": strconv.Itoa(len(events)),
+			"first": displayTimestamp(first.Timestamp),
+			"last":  displayTimestamp(last.Timestamp),
+		},
+	}
+	if len(devices) > 0 {
+		summary.Data["devices"] = strings.Join(devices, ", ")
+	}
+	return summary
+}
@@ -0,0 +1,30 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestSummarizeEventsFiltersDevices(t *testing.T) {
+	events := []incomingWebhook{
+		{Type: "nodeKeyExpired", Data: map[string]string{"nodeID": "n1", "deviceName": "laptop.example.ts.net", "hostname": "laptop"}},
+		{Type: "nodeKeyExpired", Data: map[string]string{"nodeID": "n2", "deviceName": "phone.example.ts.net"}},
+		{Type: "nodeKeyExpired", Data: map[string]string{"nodeID": "n1", "hostname": "laptop"}},
+	}
+	for _, tt := range []struct {
+		env  map[string]string
+		want string
+	}{
+		{nil, "laptop.example.ts.net, phone.example.ts.net, laptop"},
+		{map[string]string{"DROP_FIELDS": "deviceName"}, "laptop, n2"},
+		{map[string]string{"REDACT_FIELDS": "deviceName,hostname"}, "n1, n2"},
+		{map[string]string{"INCLUDE_FIELDS": "nodeID"}, "n1, n2"},
+		{map[string]string{"REDACT_FIELDS": "deviceName,hostname,nodeID"}, ""},
+	} {
+		setTestConfig(t, tt.env)
+		if got := summarizeEvents(events).Data["devices"]; got != tt.want {
+			t.Errorf("%v: devices = %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
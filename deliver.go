@@ -0,0 +1,377 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// httpClient is shared by all destinations so that connections are reused.
+// Its timeout is set from HTTP_TIMEOUT.
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+var (
+	clientsMu sync.Mutex
+	clients   = map[string]*http.Client{} // by destination
+)
+
+// destinationClient reports the client used for dest. It shares the
+// connections of httpClient, but uses the <DEST>_TIMEOUT, if one is set,
+// instead of HTTP_TIMEOUT. Clients are made on first use, once httpClient
+// has been configured, and reused after that.
+func destinationClient(c *Config, dest string) *http.Client {
+	timeout, ok := c.Timeouts[dest]
+	if !ok {
+		return httpClient
+	}
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if client := clients[dest]; client != nil && client.Timeout == timeout {
+		return client
+	}
+	client := *httpClient
+	client.Timeout = timeout
+	clients[dest] = &client
+	return &client
+}
+
+// configureOutboundTLS applies OUTBOUND_CA_FILE and
+// OUTBOUND_INSECURE_SKIP_VERIFY to the shared outbound transport, for
+// self-hosted destinations with certificates from a private CA.
+func configureOutboundTLS() error {
+	caFile := cfg.Outbound.CAFile
+	insecure := cfg.Outbound.InsecureSkipVerify
+	if caFile == "" && !insecure {
+		return nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if insecure {
+		log.Printf("WARNING: OUTBOUND_INSECURE_SKIP_VERIFY is set; certificates of destinations are NOT verified. Never use this in production.")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	httpClient.Transport = transport
+	return nil
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// retryBackoff reports how long to wait before retry number n (from 1),
+// using exponential backoff with full jitter: the delay is drawn uniformly
+// from [0, min(RETRY_BACKOFF_MAX, RETRY_BACKOFF_BASE * 2^(n-1))). Spreading
+// retries out this way keeps a destination that has just recovered from
+// being hit by every queued delivery at once.
+func retryBackoff(n int) time.Duration {
+	base := cfg.Retry.BackoffBase
+	ceiling := cfg.Retry.BackoffMax
+	d := base
+	for i := 1; i < n && d < ceiling; i++ {
+		d *= 2
+	}
+	d = min(d, ceiling)
+	if d <= 0 {
+		return 0
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(d)))
+}
+
+// outboundRequest is a single HTTP delivery to a destination.
+type outboundRequest struct {
+	dest   string       // used in logs and dead letters, e.g. "discord"
+	client *http.Client // defaults to httpClient
+	method string       // defaults to POST
+	url    string
+	header http.Header
+	body   []byte
+
+	// retryAfter optionally reads how long to wait before retrying from a
+	// failed response, for destinations that don't (only) use the
+	// Retry-After header.
+	retryAfter func(resp *http.Response, body []byte) (time.Duration, bool)
+
+	// check optionally reports an error in a successful response, for
+	// APIs that report errors with a 2xx status. Such errors are not
+	// retried.
+	check func(body []byte) error
+}
+
+// deliveryError is returned for responses that were not successful.
+type deliveryError struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+func (e *deliveryError) Error() string {
+	return fmt.Sprintf("unexpected status %s: %s", e.Status, bytes.TrimSpace(e.Body))
+}
+
+// defaultRetryStatuses are the response statuses retried by default.
+var defaultRetryStatuses = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// deliver sends the request, reports the outcome to the delivery observers
+// (which dead-letter it if it could not be delivered) and returns the
+// response body.
+func deliver(r outboundRequest) ([]byte, error) {
+	observePayload(r.dest, r.body)
+	if cfg.DryRun {
+		log.Printf("deliver %s (dry run): %s", r.dest, r.body)
+		return nil, nil
+	}
+	start := time.Now()
+	body, err := deliverWithRetry(r)
+	res := newDeliveryResult(r.dest, start, err)
+	res.request = &r
+	reportDelivery(res)
+	return body, err
+}
+
+// deliverWithRetry sends the request and returns the response body.
+// Network errors and responses with a retryable status (RETRY_STATUSES)
+// are retried with jittered exponential backoff (see retryBackoff),
+// honoring Retry-After, up to RETRY_MAX_ATTEMPTS attempts in total.
+//
+// A Retry-After longer than MAX_RETRY_AFTER, or a retry that would take the
+// delivery past MAX_RETRY_ELAPSED since the first attempt, gives up early
+// so a single delivery can't monopolize the handler during sustained rate
+// limiting.
+func deliverWithRetry(r outboundRequest) ([]byte, error) {
+	maxAttempts := cfg.Retry.MaxAttempts
+	maxRetryAfter := cfg.Retry.MaxRetryAfter
+	maxElapsed := cfg.Retry.MaxElapsed
+
+	statuses := cfg.Retry.StatusesFor(r.dest)
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		body, resp, err := doRequest(r)
+		if err == nil {
+			if r.check != nil {
+				if err := r.check(body); err != nil {
+					return nil, err
+				}
+			}
+			return body, nil
+		}
+
+		if errors.Is(err, errHostNotAllowed) {
+			return nil, err
+		}
+
+		var wait time.Duration
+		var derr *deliveryError
+		if errors.As(err, &derr) {
+			if !slices.Contains(statuses, derr.StatusCode) {
+				return nil, err
+			}
+			d, ok := retryAfter(resp)
+			if r.retryAfter != nil {
+				if rd, rok := r.retryAfter(resp, derr.Body); rok {
+					d, ok = rd, rok
+				}
+			}
+			if ok {
+				if d > maxRetryAfter {
+					err = fmt.Errorf("%w (Retry-After %v exceeds MAX_RETRY_AFTER %v)", err, d, maxRetryAfter)
+					return nil, err
+				}
+				wait = d
+			}
+		}
+		if wait == 0 {
+			wait = retryBackoff(attempt)
+		}
+
+		if attempt >= maxAttempts {
+			err = fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			return nil, err
+		}
+		if time.Since(start)+wait > maxElapsed {
+			err = fmt.Errorf("giving up after %v (MAX_RETRY_ELAPSED %v): %w", time.Since(start).Round(time.Millisecond), maxElapsed, err)
+			return nil, err
+		}
+
+		log.Printf("deliver %s attempt %d failed, retrying in %v: %v", r.dest, attempt, wait, err)
+		time.Sleep(wait)
+	}
+}
+
+// doRequest makes a single attempt at the request.
+func doRequest(r outboundRequest) ([]byte, *http.Response, error) {
+	method := r.method
+	if method == "" {
+		method = http.MethodPost
+	}
+	payload := r.body
+	// Bodies with a Content-Encoding, such as signed forwarded batches,
+	// were compressed by their sender.
+	compressed := cfg.Gzip[r.dest] && len(payload) >= cfg.GzipMinBytes && r.header.Get("Content-Encoding") == ""
+	if compressed {
+		var err error
+		if payload, err = gzipBody(payload); err != nil {
+			return nil, nil, err
+		}
+	}
+	req, err := http.NewRequest(method, r.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", userAgent())
+	}
+	if req.Header.Get("Content-Type") == "" && r.body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := r.client
+	if client == nil {
+		client = httpClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, resp, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, resp, &deliveryError{StatusCode: resp.StatusCode, Status: resp.Status, Body: body}
+	}
+	return body, resp, nil
+}
+
+// gzipBody compresses an outgoing request body, for destinations with
+// <DEST>_GZIP set.
+func gzipBody(b []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// retryAfter parses the Retry-After header of a response, which may be
+// either a number of seconds or an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs >= 0 {
+		return time.Duration(secs * float64(time.Second)), true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+type deadLetterRecord struct {
+	Time        time.Time       `json:"time"`
+	Destination string          `json:"destination"`
+	Error       string          `json:"error"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	RawBody     string          `json:"rawBody,omitempty"`
+}
+
+var deadLetterMu sync.Mutex
+
+// deadLetter records a request that could not be delivered. It is always
+// logged, and appended as a JSON line to DEAD_LETTER_FILE if set, so that
+// it can be inspected or replayed later. Destination URLs often embed
+// credentials, so they are not recorded.
+func deadLetter(r outboundRequest, err error) {
+	log.Printf("deliver %s failed, dead-lettering: %v", r.dest, err)
+
+	filename := cfg.DeadLetterFile
+	if filename == "" {
+		return
+	}
+	rec := deadLetterRecord{
+		Time:        time.Now().UTC(),
+		Destination: r.dest,
+		Error:       err.Error(),
+	}
+	if json.Valid(r.body) {
+		rec.Body = r.body
+	} else {
+		rec.RawBody = string(r.body)
+	}
+	line, mErr := json.Marshal(rec)
+	if mErr != nil {
+		log.Printf("deadLetter json.Marshal failed: %v", mErr)
+		return
+	}
+
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	f, fErr := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if fErr != nil {
+		log.Printf("deadLetter opening %s failed: %v", filename, fErr)
+		return
+	}
+	defer f.Close()
+	if _, fErr := f.Write(append(line, '\n')); fErr != nil {
+		log.Printf("deadLetter writing %s failed: %v", filename, fErr)
+	}
+}
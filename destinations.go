@@ -0,0 +1,152 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// destination is a service that events are forwarded to. Each send
+// function is a no-op when its destination is not configured in c, and
+// reports whether the event could not be delivered.
+type destination struct {
+	name       string
+	send       func(c *Config, orig incomingWebhook) error
+	configured func(c *Config) bool
+}
+
+var destinations = []destination{
+	{
+		"teams",
+		func(c *Config, orig incomingWebhook) error {
+			return sendTeamsWebhook(c.Teams, destinationClient(c, "teams"), orig)
+		},
+		func(c *Config) bool { return c.Teams.WebhookURL != "" },
+	},
+	{
+		"discord",
+		func(c *Config, orig incomingWebhook) error {
+			return sendDiscordWebhook(c.Discord, destinationClient(c, "discord"), orig)
+		},
+		func(c *Config) bool {
+			return c.Discord.WebhookURL != "" || (c.Discord.BotToken != "" && c.Discord.ChannelID != "")
+		},
+	},
+	{
+		"slack",
+		func(c *Config, orig incomingWebhook) error {
+			return sendSlackMessage(c.Slack, destinationClient(c, "slack"), orig)
+		},
+		func(c *Config) bool {
+			return c.Slack.WebhookURL != "" || (c.Slack.BotToken != "" && c.Slack.Channel != "")
+		},
+	},
+	{
+		"generic",
+		func(c *Config, orig incomingWebhook) error {
+			return sendGenericWebhook(c.Generic, destinationClient(c, "generic"), orig)
+		},
+		func(c *Config) bool { return c.Generic.URL != "" },
+	},
+	{
+		"sqs",
+		func(c *Config, orig incomingWebhook) error { return sendSQSMessage(c.SQS, orig) },
+		func(c *Config) bool { return c.SQS.QueueURL != "" },
+	},
+	{
+		"signal",
+		func(c *Config, orig incomingWebhook) error {
+			return sendSignalMessage(c.Signal, destinationClient(c, "signal"), orig)
+		},
+		func(c *Config) bool {
+			return c.Signal.APIURL != "" && c.Signal.Number != "" && len(c.Signal.Recipients) > 0
+		},
+	},
+	{
+		"pubsub",
+		func(c *Config, orig incomingWebhook) error { return sendPubSubMessage(c.PubSub, orig) },
+		func(c *Config) bool { return c.PubSub.Project != "" && c.PubSub.Topic != "" },
+	},
+	{
+		"line",
+		func(c *Config, orig incomingWebhook) error {
+			return sendLineMessage(c.Line, destinationClient(c, "line"), orig)
+		},
+		func(c *Config) bool { return c.Line.Token != "" && c.Line.To != "" },
+	},
+	{
+		"mastodon",
+		func(c *Config, orig incomingWebhook) error {
+			return sendMastodonStatus(c.Mastodon, destinationClient(c, "mastodon"), orig)
+		},
+		func(c *Config) bool { return c.Mastodon.URL != "" && c.Mastodon.Token != "" },
+	},
+	{
+		"file",
+		func(c *Config, orig incomingWebhook) error { return sendEventLog(c.EventLog, orig) },
+		func(c *Config) bool { return c.EventLog.File != "" },
+	},
+	{
+		"stdout",
+		func(c *Config, orig incomingWebhook) error { return sendStdoutEvent(c.Stdout, orig) },
+		func(c *Config) bool { return c.Stdout.Enabled },
+	},
+}
+
+// configuredDestinations reports the names of the destinations that
+// events will be sent to, including the FORWARD_URL instance, which
+// batches are forwarded to before they are dispatched.
+func configuredDestinations() []string {
+	var names []string
+	for _, d := range destinations {
+		if d.configured(cfg) {
+			names = append(names, d.name)
+		}
+	}
+	if cfg.Forward.URL != "" {
+		names = append(names, "forward")
+	}
+	return names
+}
+
+// sendTo sends the event to a single destination, unless the destination
+// has reached MAX_MESSAGES_PER_MINUTE or, with RETRY_GRANULARITY=batch,
+// already received the event in an earlier delivery of its batch.
+func sendTo(d destination, event incomingWebhook) error {
+	batch := cfg.Retry.Granularity == retryGranularityBatch
+	if batch && redeliveries.delivered(d.name, event) {
+		debugf("sendTo %s skipped %s event, delivered before the batch was sent again", d.name, event.Type)
+		return nil
+	}
+	if flood.suppress(d, event) {
+		return nil
+	}
+	start := time.Now()
+	err := sendNow(d, event)
+	recordRecent(event, d.name, time.Since(start), err)
+	if batch && err == nil {
+		redeliveries.add(d.name, event)
+	}
+	return err
+}
+
+// sendNow sends the event to a single destination. A panic in the
+// destination's sender is recovered and logged, so that a bug in one
+// destination doesn't keep the event from the others.
+func sendNow(d destination, event incomingWebhook) (err error) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("sendTo %s panicked on %s event %q: %v\n%s", d.name, event.Type, event.Message, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+			recordDelivery(d.name, start, err)
+		}
+	}()
+	waitWarmUp()
+	return d.send(cfg, event)
+}
@@ -0,0 +1,451 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"cloud.google.com/go/pubsub/v2/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/v2/pstest"
+)
+
+// recordedRequest is a request received by a stubDestination.
+type recordedRequest struct {
+	Method string
+	URL    *url.URL // as sent, before it was redirected to the stub
+	Header http.Header
+	Body   []byte
+}
+
+// stubDestination is a loopback server standing in for a destination. Its
+// client sends every request there, whatever the URL, so that senders with
+// fixed APIs can be tested too.
+type stubDestination struct {
+	client *http.Client
+
+	mu       sync.Mutex
+	requests []recordedRequest
+	response string // the body of every response, unless reply is set
+	reply    func(body []byte) string
+}
+
+func newStubDestination(t testing.TB, response string) *stubDestination {
+	t.Helper()
+	s := &stubDestination{response: response}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		u, _ := url.Parse(r.Header.Get("X-Stub-Original-Url"))
+		r.Header.Del("X-Stub-Original-Url")
+		s.mu.Lock()
+		s.requests = append(s.requests, recordedRequest{Method: r.Method, URL: u, Header: r.Header, Body: body})
+		response := s.response
+		if s.reply != nil {
+			response = s.reply(body)
+		}
+		s.mu.Unlock()
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+	s.client = &http.Client{Transport: stubTransport{target: target, base: srv.Client().Transport}}
+	return s
+}
+
+// sent reports the requests received so far.
+func (s *stubDestination) sent() []recordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]recordedRequest(nil), s.requests...)
+}
+
+type stubTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+}
+
+func (t stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Stub-Original-Url", req.URL.String())
+	req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	req.Host = ""
+	return t.base.RoundTrip(req)
+}
+
+// testEvent is a representative event for the sender tests.
+var testEvent = incomingWebhook{
+	Timestamp: "2024-01-02T15:04:05Z",
+	Version:   1,
+	Type:      "nodeCreated",
+	Tailnet:   "example.com",
+	Message:   "Node laptop created",
+	Data: map[string]string{
+		"nodeID":     "n123",
+		"deviceName": "laptop.example.ts.net",
+		"actor":      "alice@example.com",
+	},
+}
+
+// decodeSent decodes the body of the single request stub received into v.
+func decodeSent(t *testing.T, stub *stubDestination, v any) recordedRequest {
+	t.Helper()
+	sent := stub.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d requests, want 1", len(sent))
+	}
+	if err := json.Unmarshal(sent[0].Body, v); err != nil {
+		t.Fatalf("decoding %s: %v", sent[0].Body, err)
+	}
+	return sent[0]
+}
+
+func TestSendTeamsWebhook(t *testing.T) {
+	c := setTestConfig(t, map[string]string{"TEAMS_WEBHOOK_URL": "https://example.webhook.office.com/webhookb2/x"})
+	stub := newStubDestination(t, "1")
+	if err := sendTeamsWebhook(c.Teams, stub.client, testEvent); err != nil {
+		t.Fatal(err)
+	}
+	var card teamsWebhook
+	req := decodeSent(t, stub, &card)
+	if req.URL.String() != c.Teams.WebhookURL {
+		t.Errorf("URL = %s", req.URL)
+	}
+	if card.Type != "MessageCard" || card.Title != eventLabel(testEvent.Type) || card.Summary != testEvent.Message {
+		t.Errorf("card = %+v", card)
+	}
+	content, _ := json.Marshal(card.Attachments[0].Content)
+	for _, want := range []string{testEvent.Message, "laptop.example.ts.net", "n123"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("card content lacks %q: %s", want, content)
+		}
+	}
+	if len(card.Actions) != 1 {
+		t.Errorf("actions = %+v, want a link to the admin console", card.Actions)
+	}
+}
+
+func TestSendDiscordWebhook(t *testing.T) {
+	c := setTestConfig(t, map[string]string{"DISCORD_WEBHOOK_URL": "https://discord.com/api/webhooks/1/token"})
+	stub := newStubDestination(t, `{"id":"2","channel_id":"3"}`)
+	if err := sendDiscordWebhook(c.Discord, stub.client, testEvent); err != nil {
+		t.Fatal(err)
+	}
+	var msg discordWebhook
+	req := decodeSent(t, stub, &msg)
+	if req.URL.Path != "/api/webhooks/1/token" || req.URL.Query().Get("wait") != "true" {
+		t.Errorf("URL = %s", req.URL)
+	}
+	if len(msg.Embeds) != 1 || msg.Embeds[0].Title != eventLabel(testEvent.Type) || msg.Embeds[0].Description != testEvent.Message {
+		t.Errorf("embeds = %+v, want the label as title and the message as description", msg.Embeds)
+	}
+	if !strings.Contains(msg.Content, `deviceName="laptop.example.ts.net"`) {
+		t.Errorf("content = %q, want the fields", msg.Content)
+	}
+	if len(msg.Components) != 1 {
+		t.Errorf("components = %+v, want a link button", msg.Components)
+	}
+}
+
+func TestDiscordFieldOrderIsStable(t *testing.T) {
+	data := map[string]string{}
+	for _, k := range []string{"nodeID", "deviceName", "actor", "os", "addresses", "tags", "user", "url", "expiry", "routes"} {
+		data[k] = "value of " + k
+	}
+	for _, tt := range []struct {
+		name   string
+		fields string
+		want   []string
+	}{
+		{"sorted", "", sortedKeys(data)},
+		{"INCLUDE_FIELDS", "user,deviceName,os", []string{"user", "deviceName", "os"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := setTestConfig(t, map[string]string{
+				"DISCORD_WEBHOOK_URL": "https://discord.com/api/webhooks/1/token",
+				"INCLUDE_FIELDS":      tt.fields,
+			})
+			stub := newStubDestination(t, `{"id":"2","channel_id":"3"}`)
+			event := testEvent
+			event.Data = data
+			for range 20 {
+				if err := sendDiscordWebhook(c.Discord, stub.client, event); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sent := stub.sent()
+			for i, req := range sent[1:] {
+				if string(req.Body) != string(sent[0].Body) {
+					t.Fatalf("payload %d differs from the first:\n%s\n%s", i+1, req.Body, sent[0].Body)
+				}
+			}
+			var msg discordWebhook
+			if err := json.Unmarshal(sent[0].Body, &msg); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, line := range strings.Split(msg.Content, "\n") {
+				if k, _, ok := strings.Cut(line, "="); ok {
+					got = append(got, k)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("fields are in order %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendDiscordBotMessage(t *testing.T) {
+	c := setTestConfig(t, map[string]string{"DISCORD_BOT_TOKEN": "bot", "DISCORD_CHANNEL_ID": "42"})
+	stub := newStubDestination(t, `{"id":"2","channel_id":"42"}`)
+	if err := sendDiscordWebhook(c.Discord, stub.client, testEvent); err != nil {
+		t.Fatal(err)
+	}
+	var msg discordWebhook
+	req := decodeSent(t, stub, &msg)
+	if got, want := req.URL.String(), discordAPIBaseURL+"/channels/42/messages"; got != want {
+		t.Errorf("URL = %s, want %s", got, want)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bot bot" {
+		t.Errorf("Authorization = %q", got)
+	}
+}
+
+func TestSendSlackMessage(t *testing.T) {
+	c := setTestConfig(t, map[string]string{"SLACK_WEBHOOK_URL": "https://hooks.slack.com/services/x"})
+	stub := newStubDestination(t, "ok")
+	if err := sendSlackMessage(c.Slack, stub.client, testEvent); err != nil {
+		t.Fatal(err)
+	}
+	var msg slackMessage
+	decodeSent(t, stub, &msg)
+	if want := eventLabel(testEvent.Type) + ": " + testEvent.Message; msg.Text != want {
+		t.Errorf("text = %q, want %q", msg.Text, want)
+	}
+	if len(msg.Attachments) != 1 || len(msg.Attachments[0].Fields) != len(testEvent.Data) {
+		t.Errorf("attachments = %+v, want one with every field", msg.Attachments)
+	}
+}
+
+func TestSendSlackBotMessage(t *testing.T) {
+	c := setTestConfig(t, map[string]string{"SLACK_BOT_TOKEN": "xoxb", "SLACK_CHANNEL": "C1"})
+	stub := newStubDestination(t, `{"ok":true,"channel":"C1","ts":"1.2"}`)
+	if err := sendSlackMessage(c.Slack, stub.client, testEvent); err != nil {
+		t.Fatal(err)
+	}
+	var msg slackMessage
+	req := decodeSent(t, stub, &msg)
+	if got := req.URL.String(); got != slackAPIBaseURL+"/chat.postMessage" {
+		t.Errorf("URL = %s", got)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer xoxb" || msg.Channel != "C1" {
+		t.Errorf("Authorization = %q, channel = %q", got, msg.Channel)
+	}
+}
+
+func TestSlackRawJSONAndLimit(t *testing.T) {
+	long := strings.Repeat("é", 300)
+	event := testEvent
+	event.Message = long
+	c := setTestConfig(t, map[string]string{
+		"SLACK_WEBHOOK_URL": "https://hooks.slack.com/services/x",
+		"SLACK_MAX_LENGTH":  "200",
+		"INCLUDE_RAW_JSON":  "true",
+	})
+	stub := newStubDestination(t, "ok")
+	if err := sendSlackMessage(c.Slack, stub.client, event); err != nil {
+		t.Fatal(err)
+	}
+	var msg slackMessage
+	decodeSent(t, stub, &msg)
+	if n := utf8.RuneCountInString(msg.Text); n > 200 {
+		t.Errorf("text is %d characters, want at most 200", n)
+	}
+	if n := utf8.RuneCountInString(msg.Attachments[0].Text); n > 200 || !strings.HasSuffix(msg.Attachments[0].Text, truncationMarker) {
+		t.Errorf("attachment text is %d characters, want the message truncated to 200", n)
+	}
+
+	c = setTestConfig(t, map[string]string{"SLACK_WEBHOOK_URL": "https://hooks.slack.com/services/x", "INCLUDE_RAW_JSON": "true"})
+	stub = newStubDestination(t, "ok")
+	if err := sendSlackMessage(c.Slack, stub.client, testEvent); err != nil {
+		t.Fatal(err)
+	}
+	decodeSent(t, stub, &msg)
+	if want := testEvent.Message + "\n```\n" + rawEventJSON(testEvent) + "\n```"; msg.Attachments[0].Text != want {
+		t.Errorf("attachment text = %q, want the message and the raw event in a code block", msg.Attachments[0].Text)
+	}
+}
+
+func TestSendGenericWebhook(t *testing.T) {
+	c := setTestConfig(t, map[string]string{"GENERIC_WEBHOOK_URL": "https://events.example.com/ingest"})
+	stub := newStubDestination(t, "")
+	if err := sendGenericWebhook(c.Generic, stub.client, testEvent); err != nil {
+		t.Fatal(err)
+	}
+	var got incomingWebhook
+	req := decodeSent(t, stub, &got)
+	if req.URL.String() != c.Generic.URL || req.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("URL = %s, Content-Type = %q", req.URL, req.Header.Get("Content-Type"))
+	}
+	if req.Header.Get("Idempotency-Key") != eventHash(testEvent) {
+		t.Errorf("Idempotency-Key = %q, want the event hash", req.Header.Get("Idempotency-Key"))
+	}
+	if got.Type != testEvent.Type || got.Message != testEvent.Message || got.Data["nodeID"] != "n123" {
+		t.Errorf("event = %+v", got)
+	}
+}
+
+func TestSendSignalMessage(t *testing.T) {
+	c := setTestConfig(t, map[string]string{
+		"SIGNAL_API_URL":    "http://signal-cli:8080/",
+		"SIGNAL_NUMBER":     "+15550000000",
+		"SIGNAL_RECIPIENTS": "+15550000001,+15550000002",
+	})
+	stub := newStubDestination(t, `{}`)
+	if err := sendSignalMessage(c.Signal, stub.client, testEvent); err != nil {
+		t.Fatal(err)
+	}
+	var msg signalMessage
+	req := decodeSent(t, stub, &msg)
+	if got := req.URL.String(); got != "http://signal-cli:8080/v2/send" {
+		t.Errorf("URL = %s", got)
+	}
+	if msg.Number != "+15550000000" || len(msg.Recipients) != 2 || !strings.Contains(msg.Message, testEvent.Message) {
+		t.Errorf("message = %+v", msg)
+	}
+}
+
+func TestSendMastodonStatus(t *testing.T) {
+	c := setTestConfig(t, map[string]string{"MASTODON_URL": "https://mastodon.example", "MASTODON_TOKEN": "token"})
+	stub := newStubDestination(t, `{}`)
+	if err := sendMastodonStatus(c.Mastodon, stub.client, testEvent); err != nil {
+		t.Fatal(err)
+	}
+	var status mastodonStatus
+	req := decodeSent(t, stub, &status)
+	if got := req.URL.String(); got != "https://mastodon.example/api/v1/statuses" {
+		t.Errorf("URL = %s", got)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if status.Visibility != "unlisted" || !strings.Contains(status.Status, testEvent.Message) {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestSendEventLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "events.jsonl")
+	c := setTestConfig(t, map[string]string{"EVENT_LOG_FILE": file})
+	if eventLogFile != nil {
+		t.Skip("the event log was opened by an earlier test")
+	}
+	t.Cleanup(func() { closeEventLog() })
+	for range 2 {
+		if err := sendEventLog(c.EventLog, testEvent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("wrote %d lines, want 2:\n%s", len(lines), b)
+	}
+	var got incomingWebhook
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil || got.Message != testEvent.Message {
+		t.Errorf("line = %s (%v)", lines[0], err)
+	}
+}
+
+func TestSendSQSMessage(t *testing.T) {
+	c := setTestConfig(t, map[string]string{"SQS_QUEUE_URL": "https://sqs.us-east-1.amazonaws.com/123456789012/events.fifo"})
+	setTestAWSEnv(t)
+	stub := newStubDestination(t, "")
+	stub.reply = func(body []byte) string {
+		var in struct{ MessageBody string }
+		json.Unmarshal(body, &in)
+		return fmt.Sprintf(`{"MessageId":"m1","MD5OfMessageBody":"%x"}`, md5.Sum([]byte(in.MessageBody)))
+	}
+	old := httpClient.Transport
+	httpClient.Transport = stub.client.Transport
+	t.Cleanup(func() { httpClient.Transport = old })
+
+	if err := sendSQSMessage(c.SQS, testEvent); err != nil {
+		t.Fatal(err)
+	}
+	var in struct {
+		QueueUrl               string
+		MessageBody            string
+		MessageGroupId         string
+		MessageDeduplicationId string
+	}
+	req := decodeSent(t, stub, &in)
+	if got := req.Header.Get("X-Amz-Target"); got != "AmazonSQS.SendMessage" {
+		t.Errorf("X-Amz-Target = %q", got)
+	}
+	if in.QueueUrl != c.SQS.QueueURL || in.MessageGroupId != testEvent.Tailnet || in.MessageDeduplicationId != eventHash(testEvent) {
+		t.Errorf("input = %+v", in)
+	}
+	var got incomingWebhook
+	if err := json.Unmarshal([]byte(in.MessageBody), &got); err != nil || got.Message != testEvent.Message {
+		t.Errorf("message body = %s (%v)", in.MessageBody, err)
+	}
+}
+
+// setTestAWSEnv sets static AWS credentials and a region, so that the SQS
+// client doesn't look for real ones.
+func setTestAWSEnv(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+}
+
+func TestSendPubSubMessage(t *testing.T) {
+	if pubsubClient != nil {
+		t.Skip("the Pub/Sub client was created by an earlier test")
+	}
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+	topic := "projects/project/topics/events"
+	if _, err := srv.GServer.CreateTopic(t.Context(), &pubsubpb.Topic{Name: topic}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PUBSUB_EMULATOR_HOST", srv.Addr)
+	c := setTestConfig(t, map[string]string{"PUBSUB_PROJECT": "project", "PUBSUB_TOPIC": "events"})
+
+	if err := sendPubSubMessage(c.PubSub, testEvent); err != nil {
+		t.Fatal(err)
+	}
+	if err := closePubSub(); err != nil {
+		t.Fatal(err)
+	}
+	msgs := srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("published %d messages, want 1", len(msgs))
+	}
+	if got := msgs[0].Attributes; got["type"] != testEvent.Type || got["tailnet"] != testEvent.Tailnet {
+		t.Errorf("attributes = %v", got)
+	}
+	var got incomingWebhook
+	if err := json.Unmarshal(msgs[0].Data, &got); err != nil || got.Message != testEvent.Message {
+		t.Errorf("data = %s (%v)", msgs[0].Data, err)
+	}
+}
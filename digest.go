@@ -0,0 +1,148 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// digestExamples is how many example details are listed per event type.
+const digestExamples = 3
+
+// digestStore accumulates events for the periodic digest.
+type digestStore struct {
+	mu     sync.Mutex
+	since  time.Time
+	events []incomingWebhook
+}
+
+var digest = &digestStore{since: time.Now()}
+
+func (d *digestStore) add(orig incomingWebhook) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.events = append(d.events, orig)
+}
+
+// Close sends the events collected so far, so that they aren't lost on
+// shutdown.
+func (d *digestStore) Close() error {
+	d.flush()
+	return nil
+}
+
+// flush delivers the accumulated events as a single summary, if any.
+func (d *digestStore) flush() {
+	d.mu.Lock()
+	events, since := d.events, d.since
+	d.events, d.since = nil, time.Now()
+	d.mu.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+	byTailnet := map[string][]incomingWebhook{}
+	for _, e := range events {
+		byTailnet[e.Tailnet] = append(byTailnet[e.Tailnet], e)
+	}
+	for _, tailnet := range sortedKeys(byTailnet) {
+		events := byTailnet[tailnet]
+		log.Printf("digest sending summary of %d events for tailnet %q", len(events), tailnet)
+		deliverEvent(summarizeDigest(events, since))
+	}
+}
+
+// summarizeDigest groups events of one tailnet by type, with counts and a
+// few examples. The examples are taken from the filtered data, so that
+// redacted and dropped fields aren't listed.
+func summarizeDigest(events []incomingWebhook, since time.Time) incomingWebhook {
+	byType := map[string][]incomingWebhook{}
+	for _, e := range events {
+		byType[e.Type] = append(byType[e.Type], e)
+	}
+	types := make([]string, 0, len(byType))
+	for t := range byType {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	data := make(map[string]string, len(types))
+	for _, t := range types {
+		var examples []string
+		for _, e := range byType[t] {
+			if len(examples) == digestExamples {
+				break
+			}
+			ex := e.Message
+			data := filterData(e.Data)
+			for _, k := range []string{"deviceName", "hostname", "user", "actor"} {
+				if v := data[k]; v != "" && v != redactedValue {
+					ex = v
+					break
+				}
+			}
+			if !slices.Contains(examples, ex) {
+				examples = append(examples, ex)
+			}
+		}
+		summary := fmt.Sprint(len(byType[t]))
+		if len(examples) > 0 {
+			summary += " (" + strings.Join(examples, ", ")
+			if len(byType[t]) > len(examples) {
+				summary += ", …"
+			}
+			summary += ")"
+		}
+		data[eventLabel(t)] = summary
+	}
+
+	return incomingWebhook{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Version:   1,
+		Type:      "digest",
+		Tailnet:   events[0].Tailnet,
+		Message:   fmt.Sprintf(tr("digestSummary"), len(events), displayTime(since)),
+		Data:      data,
+	}
+}
+
+// runDigest flushes the digest every DIGEST_INTERVAL, or daily at the
+// time of day in DIGEST_AT (in QUIET_HOURS_TZ, or the system timezone).
+func runDigest(interval time.Duration, at time.Duration, loc *time.Location) {
+	for {
+		var wait time.Duration
+		if interval > 0 {
+			wait = interval
+		} else {
+			wait = untilTimeOfDay(time.Now().In(loc), at)
+		}
+		time.Sleep(wait)
+		digest.flush()
+	}
+}
+
+// untilTimeOfDay reports how long it is from now until the next time the
+// clock shows the given time of day.
+func untilTimeOfDay(now time.Time, at time.Duration) time.Duration {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	next := midnight.Add(at)
+	if !next.After(now) {
+		next = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location()).Add(at)
+	}
+	return next.Sub(now)
+}
+
+// startDigest starts the digest schedule, if one is configured.
+func startDigest(c DigestConfig) {
+	if c.Enabled() {
+		go runDigest(c.Interval, c.At, c.Location)
+	}
+}
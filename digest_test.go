@@ -0,0 +1,77 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSummarizeDigestFiltersExamples(t *testing.T) {
+	setTestConfig(t, map[string]string{"REDACT_FIELDS": "deviceName", "DROP_FIELDS": "user"})
+	events := []incomingWebhook{
+		{Type: "nodeCreated", Message: "Node created", Data: map[string]string{"deviceName": "laptop.example.ts.net", "hostname": "laptop"}},
+		{Type: "nodeCreated", Message: "Node created", Data: map[string]string{"deviceName": "phone.example.ts.net"}},
+		{Type: "userCreated", Message: "User created", Data: map[string]string{"user": "bob@example.com", "actor": "alice@example.com"}},
+	}
+	got := summarizeDigest(events, time.Now()).Data
+	want := map[string]string{
+		eventLabel("nodeCreated"): "2 (laptop, Node created)",
+		eventLabel("userCreated"): "1 (alice@example.com)",
+	}
+	if len(got) != len(want) {
+		t.Errorf("data = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestDigestPerTailnet(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		sent []incomingWebhook
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var e incomingWebhook
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Errorf("decoding digest: %v", err)
+		}
+		mu.Lock()
+		sent = append(sent, e)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	setTestConfig(t, map[string]string{"GENERIC_WEBHOOK_URL": srv.URL, "DELIVERY_MODE": "sync"})
+
+	d := &digestStore{since: time.Now()}
+	d.add(incomingWebhook{Type: "nodeCreated", Tailnet: "a.example.com", Message: "Node created"})
+	d.add(incomingWebhook{Type: "nodeCreated", Tailnet: "b.example.com", Message: "Node created"})
+	d.add(incomingWebhook{Type: "userCreated", Tailnet: "a.example.com", Message: "User created"})
+	d.flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d digests, want one per tailnet", len(sent))
+	}
+	sort.Slice(sent, func(i, j int) bool { return sent[i].Tailnet < sent[j].Tailnet })
+	if sent[0].Tailnet != "a.example.com" || len(sent[0].Data) != 2 || !strings.Contains(sent[0].Message, "2") {
+		t.Errorf("first digest = %+v, want both events of a.example.com", sent[0])
+	}
+	if sent[1].Tailnet != "b.example.com" || len(sent[1].Data) != 1 {
+		t.Errorf("second digest = %+v, want the event of b.example.com", sent[1])
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tailscaleAPIBaseURL = "https://api.tailscale.com/api/v2"
+	deviceCacheTTL      = time.Minute
+)
+
+// https://tailscale.com/api#tag/devices/GET/device/{deviceId}
+type tailscaleDevice struct {
+	Hostname      string   `json:"hostname"`
+	Name          string   `json:"name"`
+	User          string   `json:"user"`
+	OS            string   `json:"os"`
+	ClientVersion string   `json:"clientVersion"`
+	Addresses     []string `json:"addresses"`
+	Tags          []string `json:"tags"`
+}
+
+type cachedDevice struct {
+	device  *tailscaleDevice
+	expires time.Time
+}
+
+var (
+	deviceCacheMu sync.Mutex
+	deviceCache   = map[string]cachedDevice{}
+)
+
+// enrichEvent adds details about the affected device to node events,
+// looked up through the Tailscale API when TS_API_KEY is set. Fields
+// already present in the event are left untouched. If the lookup fails,
+// the event is returned unchanged.
+func enrichEvent(orig incomingWebhook) incomingWebhook {
+	apiKey := cfg.TSAPIKey
+	nodeID := orig.Data["nodeID"]
+	if apiKey == "" || nodeID == "" {
+		return orig
+	}
+
+	device, err := lookupDevice(apiKey, nodeID)
+	if err != nil {
+		log.Printf("enrichEvent lookupDevice(%q) failed, using event data as is: %v", nodeID, err)
+		return orig
+	}
+
+	data := make(map[string]string, len(orig.Data)+6)
+	for k, v := range orig.Data {
+		data[k] = v
+	}
+	setIfEmpty := func(key, val string) {
+		if val != "" && data[key] == "" {
+			data[key] = val
+		}
+	}
+	setIfEmpty("hostname", device.Hostname)
+	setIfEmpty("deviceName", device.Name)
+	setIfEmpty("user", device.User)
+	setIfEmpty("os", device.OS)
+	setIfEmpty("clientVersion", device.ClientVersion)
+	setIfEmpty("addresses", strings.Join(device.Addresses, ", "))
+	setIfEmpty("tags", strings.Join(device.Tags, ", "))
+	orig.Data = data
+	return orig
+}
+
+// lookupDevice fetches a device from the Tailscale API. Results, including
+// failures, are cached briefly so that bursts of events about the same
+// device don't each cost an API call.
+func lookupDevice(apiKey, nodeID string) (*tailscaleDevice, error) {
+	deviceCacheMu.Lock()
+	cached, ok := deviceCache[nodeID]
+	deviceCacheMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		if cached.device == nil {
+			return nil, fmt.Errorf("lookup failed recently")
+		}
+		return cached.device, nil
+	}
+
+	device, err := fetchDevice(apiKey, nodeID)
+
+	deviceCacheMu.Lock()
+	defer deviceCacheMu.Unlock()
+	for id, c := range deviceCache {
+		if time.Now().After(c.expires) {
+			delete(deviceCache, id)
+		}
+	}
+	deviceCache[nodeID] = cachedDevice{device: device, expires: time.Now().Add(deviceCacheTTL)}
+	return device, err
+}
+
+func fetchDevice(apiKey, nodeID string) (*tailscaleDevice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tailscaleAPIBaseURL+"/device/"+url.PathEscape(nodeID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("User-Agent", userAgent())
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	device := new(tailscaleDevice)
+	if err := json.NewDecoder(resp.Body).Decode(device); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// eventLog appends events to a local file as JSON lines.
+type eventLog struct {
+	mu       sync.Mutex
+	filename string
+	f        *os.File
+	size     int64
+	dirty    bool
+}
+
+var (
+	eventLogOnce sync.Once
+	eventLogFile *eventLog
+)
+
+// sendEventLog appends the event to EVENT_LOG_FILE.
+func sendEventLog(c EventLogConfig, orig incomingWebhook) error {
+	filename := c.File
+	if filename == "" {
+		// not configured
+		return nil
+	}
+	eventLogOnce.Do(func() {
+		eventLogFile = &eventLog{filename: filename}
+		go eventLogFile.syncEvery(c.SyncInterval)
+	})
+
+	orig.Data = filterData(orig.Data)
+	line, err := marshalEvent(orig)
+	if err != nil {
+		log.Printf("sendEventLog json.Marshal failed: %v", err)
+		return err
+	}
+	start := time.Now()
+	err = eventLogFile.write(append(line, '\n'), c.MaxBytes)
+	recordDelivery("file", start, err)
+	if err != nil {
+		log.Printf("sendEventLog write failed: %v", err)
+		return err
+	}
+	return nil
+}
+
+// write appends b to the file, first rotating it if maxBytes is positive
+// and the write would take the file past it.
+func (l *eventLog) write(b []byte, maxBytes int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f != nil && maxBytes > 0 && l.size+int64(len(b)) > maxBytes && l.size > 0 {
+		if err := l.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	if l.f == nil {
+		f, err := os.OpenFile(l.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+		if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		l.f, l.size = f, fi.Size()
+	}
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	l.dirty = true
+	return err
+}
+
+// rotateLocked closes the current file and renames it with a timestamp
+// suffix, so that the next write starts a new file.
+func (l *eventLog) rotateLocked() error {
+	if err := l.f.Sync(); err != nil {
+		log.Printf("eventLog sync failed: %v", err)
+	}
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	l.f, l.size, l.dirty = nil, 0, false
+	return os.Rename(l.filename, l.filename+"."+time.Now().UTC().Format("20060102T150405Z"))
+}
+
+// syncEvery flushes written events to disk periodically, rather than
+// after every event.
+func (l *eventLog) syncEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		l.sync()
+	}
+}
+
+func (l *eventLog) sync() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil || !l.dirty {
+		return
+	}
+	if err := l.f.Sync(); err != nil {
+		log.Printf("eventLog sync failed: %v", err)
+	}
+	l.dirty = false
+}
+
+// closeEventLog syncs and closes the event log file, if open.
+func closeEventLog() error {
+	if eventLogFile == nil {
+		return nil
+	}
+	eventLogFile.mu.Lock()
+	defer eventLogFile.mu.Unlock()
+	if eventLogFile.f == nil {
+		return nil
+	}
+	err := eventLogFile.f.Sync()
+	if cerr := eventLogFile.f.Close(); err == nil {
+		err = cerr
+	}
+	eventLogFile.f = nil
+	return err
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expiryKeys are the data keys that may hold a key expiry time.
+var expiryKeys = []string{"expiry", "keyExpiry", "expires", "expiresAt", "expiration"}
+
+// eventExpiry reports the key expiry time in a key expiry event.
+func eventExpiry(orig incomingWebhook) (time.Time, bool) {
+	if !strings.HasPrefix(orig.Type, "nodeKeyExpir") {
+		return time.Time{}, false
+	}
+	for _, k := range expiryKeys {
+		if v := orig.Data[k]; v != "" {
+			if t, ok := parseTimestamp(v); ok {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseTimestamp parses an RFC 3339 timestamp or Unix time in seconds.
+func parseTimestamp(s string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, true
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil && secs > 0 {
+		return time.Unix(secs, 0), true
+	}
+	return time.Time{}, false
+}
+
+// annotateExpiry appends how long until the key expires, and when, to the
+// message of key expiry events, e.g. "(expires in 23h, 2024-01-02 15:04 UTC)".
+// Events without a valid expiry time are returned unchanged.
+func annotateExpiry(orig incomingWebhook) incomingWebhook {
+	t, ok := eventExpiry(orig)
+	if !ok {
+		return orig
+	}
+	var rel string
+	if d := time.Until(t); d >= 0 {
+		rel = fmt.Sprintf(tr("expiresIn"), humanDuration(d))
+	} else {
+		rel = fmt.Sprintf(tr("expiredAgo"), humanDuration(-d))
+	}
+	orig.Message = fmt.Sprintf("%s (%s, %s)", orig.Message, rel, displayTime(t))
+	return orig
+}
+
+// displayTime renders t for messages, in DISPLAY_TZ, e.g.
+// "2024-01-02 15:04 UTC".
+func displayTime(t time.Time) string {
+	return t.In(cfg.DisplayLocation).Format("2006-01-02 15:04 MST")
+}
+
+// displayTimestamp renders an event timestamp for messages, leaving it as
+// it is if it can't be parsed.
+func displayTimestamp(s string) string {
+	if t, ok := parseTimestamp(s); ok {
+		return displayTime(t)
+	}
+	return s
+}
+
+// humanDuration renders d coarsely, such as "2d 3h", "23h" or "45m".
+func humanDuration(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		days := int(d / (24 * time.Hour))
+		hours := int(d%(24*time.Hour)) / int(time.Hour)
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d.Round(time.Hour)/time.Hour))
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", int(d.Round(time.Minute)/time.Minute))
+	}
+	return fmt.Sprintf("%ds", int(d.Round(time.Second)/time.Second))
+}
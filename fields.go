@@ -0,0 +1,142 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redactedValue = "[redacted]"
+
+// filterData returns a copy of data limited to the keys listed in
+// INCLUDE_FIELDS, if any, with the keys listed in DROP_FIELDS removed and
+// the values of the keys listed in REDACT_FIELDS masked.
+func filterData(data map[string]string) map[string]string {
+	include := cfg.IncludeFields
+	drop := cfg.DropFields
+	redact := cfg.RedactFields
+	if len(include) == 0 && len(drop) == 0 && len(redact) == 0 {
+		return data
+	}
+
+	filtered := make(map[string]string, len(data))
+	for k, v := range data {
+		if len(include) == 0 || slices.Contains(include, k) {
+			filtered[k] = v
+		}
+	}
+	for _, k := range drop {
+		delete(filtered, k)
+	}
+	for _, k := range redact {
+		if _, ok := filtered[k]; ok {
+			filtered[k] = redactedValue
+		}
+	}
+	return filtered
+}
+
+// displayData reports the data fields dest shows for the event, filtered
+// by filterData and formatted by formatValue, without the policyFields of
+// policyUpdate events, which are shown as a diff instead. Destinations
+// with the terse profile show none, and those with the raw profile show
+// them as received.
+func displayData(dest string, orig incomingWebhook) map[string]string {
+	switch formatProfile(dest) {
+	case profileTerse:
+		return nil
+	case profileRaw:
+		return filterData(orig.Data)
+	}
+	data := filterData(orig.Data)
+	if orig.Type != "policyUpdate" && !cfg.FormatValues {
+		return data
+	}
+	shown := make(map[string]string, len(data))
+	for k, v := range data {
+		shown[k] = formatValue(v)
+	}
+	if orig.Type == "policyUpdate" {
+		for _, k := range policyFields {
+			delete(shown, k)
+		}
+	}
+	return shown
+}
+
+// fieldKeys reports the keys of data in the order they are shown in: the
+// order of INCLUDE_FIELDS if it is set, or else sorted.
+func fieldKeys(data map[string]string) []string {
+	if len(cfg.IncludeFields) == 0 {
+		return sortedKeys(data)
+	}
+	keys := make([]string, 0, len(data))
+	for _, k := range cfg.IncludeFields {
+		if _, ok := data[k]; ok && !slices.Contains(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// sortedKeys reports the keys of data in sorted order, so that messages
+// built from it are the same each time.
+func sortedKeys[V any](data map[string]V) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatValue renders a data value for people to read, with FORMAT_VALUES:
+// booleans as Yes/No or, with BOOLEAN_STYLE=symbols, ✓/✗, and timestamps
+// in DISPLAY_TZ. Other values are left as they are.
+func formatValue(v string) string {
+	if !cfg.FormatValues {
+		return v
+	}
+	switch strings.ToLower(v) {
+	case "true":
+		if cfg.BooleanStyle == "symbols" {
+			return "✓"
+		}
+		return tr("yes")
+	case "false":
+		if cfg.BooleanStyle == "symbols" {
+			return "✗"
+		}
+		return tr("no")
+	}
+	if t, ok := parseValueTime(v); ok {
+		return displayTime(t)
+	}
+	return v
+}
+
+// parseValueTime parses an RFC 3339 timestamp, or a Unix time in seconds or
+// milliseconds. So that counts and IDs aren't taken for times, Unix times
+// must fall between 2001 and 2286.
+func parseValueTime(v string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, true
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch len(v) {
+	case 10:
+		return time.Unix(n, 0), true
+	case 13:
+		return time.UnixMilli(n), true
+	}
+	return time.Time{}, false
+}
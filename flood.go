@@ -0,0 +1,88 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// floodWindow counts the messages sent to a destination in the minute
+// starting at start.
+type floodWindow struct {
+	start      time.Time
+	sent       int
+	suppressed map[string]int // by event type
+	tailnet    string         // of the first suppressed event
+}
+
+// floodGuard caps the messages sent to each destination at
+// MAX_MESSAGES_PER_MINUTE. Events beyond the cap are not delivered, but
+// counted, and the counts are sent as a single summary when the minute is
+// up.
+type floodGuard struct {
+	mu      sync.Mutex
+	windows map[string]*floodWindow // by destination
+}
+
+var flood = &floodGuard{windows: map[string]*floodWindow{}}
+
+// suppress reports whether the event should not be sent to d, as the cap
+// for the current minute has been reached.
+func (g *floodGuard) suppress(d destination, event incomingWebhook) bool {
+	limit := cfg.MaxMessages
+	if limit <= 0 {
+		return false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	w := g.windows[d.name]
+	if w == nil || time.Since(w.start) >= time.Minute {
+		w = &floodWindow{start: time.Now()}
+		g.windows[d.name] = w
+	}
+	if w.sent < limit {
+		w.sent++
+		return false
+	}
+	if w.suppressed == nil {
+		log.Printf("WARNING: %s reached MAX_MESSAGES_PER_MINUTE=%d; suppressing further events until %s", d.name, limit, w.start.Add(time.Minute).Format(time.TimeOnly))
+		w.suppressed = map[string]int{}
+		w.tailnet = event.Tailnet
+		time.AfterFunc(time.Until(w.start.Add(time.Minute)), func() { g.flush(d, w) })
+	}
+	w.suppressed[event.Type]++
+	debugf("suppressed %s event %q for %s", event.Type, event.Message, d.name)
+	return true
+}
+
+// flush sends the summary of the events suppressed in w, which then
+// counts towards the next minute's cap.
+func (g *floodGuard) flush(d destination, w *floodWindow) {
+	g.mu.Lock()
+	if g.windows[d.name] == w {
+		g.windows[d.name] = &floodWindow{start: time.Now(), sent: 1}
+	}
+	g.mu.Unlock()
+
+	total := 0
+	data := make(map[string]string, len(w.suppressed))
+	for t, n := range w.suppressed {
+		total += n
+		data[eventLabel(t)] = strconv.Itoa(n)
+	}
+	sendNow(d, incomingWebhook{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Version:   1,
+		Type:      "suppressed",
+		Tailnet:   w.tailnet,
+		Message:   fmt.Sprintf(tr("floodSummary"), total),
+		Data:      data,
+	})
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// eventFormatters rewrite the message of event types whose Tailscale
+// message leaves out what matters, from the event's data. A formatter
+// reports "" to leave the message as is.
+var eventFormatters = map[string]func(incomingWebhook) string{
+	"subnetIPForwardingNotEnabled":   formatSubnetForwarding,
+	"exitNodeIPForwardingNotEnabled": formatExitNodeForwarding,
+	"userRoleUpdated":                formatRoleUpdate,
+	"policyUpdate":                   formatPolicyUpdate,
+	"userApproved":                   formatUserAction("format.userApproved"),
+	"userSuspended":                  formatUserAction("format.userSuspended"),
+	"userRestored":                   formatUserAction("format.userRestored"),
+	"userDeleted":                    formatUserAction("format.userDeleted"),
+}
+
+// formatEvent rewrites the event's message with the formatter for its
+// type, if there is one.
+func formatEvent(orig incomingWebhook) incomingWebhook {
+	if f := eventFormatters[orig.Type]; f != nil {
+		if msg := f(orig); msg != "" {
+			orig.Message = msg
+		}
+	}
+	return orig
+}
+
+// routeKeys are the data keys that may hold the routes a node advertises.
+var routeKeys = []string{"routes", "advertisedRoutes", "subnetRoutes"}
+
+// eventRoutes reports the routes in the event's data, which may be
+// separated by commas or spaces.
+func eventRoutes(orig incomingWebhook) []string {
+	for _, k := range routeKeys {
+		if v := orig.Data[k]; v != "" {
+			return strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+		}
+	}
+	return nil
+}
+
+// eventDevice reports the name of the device an event is about, or its
+// node ID, or "" if it is about no device.
+func eventDevice(orig incomingWebhook) string {
+	return eventData(orig, "deviceName", "hostname", "nodeID")
+}
+
+// tailnetTitle prefixes title with the event's tailnet, as in
+// "[example.com] Node created", if include is set, which tells apart the
+// events of several tailnets posted in one channel.
+func tailnetTitle(include bool, orig incomingWebhook, title string) string {
+	if !include || orig.Tailnet == "" {
+		return title
+	}
+	return "[" + orig.Tailnet + "] " + title
+}
+
+// eventData reports the value of the first of keys set in the event's
+// data, for fields that Tailscale has named in more than one way.
+func eventData(orig incomingWebhook, keys ...string) string {
+	for _, k := range keys {
+		if v := orig.Data[k]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// eventUser reports the user an event is about, or "" if it names none.
+func eventUser(orig incomingWebhook) string {
+	return eventData(orig, "user", "userName", "loginName", "target")
+}
+
+// formatRoleUpdate states who changed whose role, from what to what. The
+// names are read from the filtered data, so that redacted users don't
+// appear in the message.
+func formatRoleUpdate(orig incomingWebhook) string {
+	orig.Data = filterData(orig.Data)
+	user := eventUser(orig)
+	newRole := eventData(orig, "newRole", "newRoles", "role")
+	if user == "" || newRole == "" {
+		return ""
+	}
+	actor := eventData(orig, "actor")
+	if actor == "" {
+		actor = tr("someone")
+	}
+	if oldRole := eventData(orig, "oldRole", "oldRoles", "previousRole"); oldRole != "" {
+		return fmt.Sprintf(tr("format.roleUpdated"), actor, user, oldRole, newRole)
+	}
+	return fmt.Sprintf(tr("format.roleUpdatedTo"), actor, user, newRole)
+}
+
+// formatUserAction returns a formatter stating which admin did something
+// to which user, with the message key such as "%s suspended %s".
+func formatUserAction(key string) func(incomingWebhook) string {
+	return func(orig incomingWebhook) string {
+		orig.Data = filterData(orig.Data)
+		actor, user := eventData(orig, "actor"), eventUser(orig)
+		if actor == "" || user == "" {
+			return ""
+		}
+		return fmt.Sprintf(tr(key), actor, user)
+	}
+}
+
+// formatSubnetForwarding states which routes are unreachable, and which
+// subnet router advertises them, as far as the filtered data shows them.
+func formatSubnetForwarding(orig incomingWebhook) string {
+	orig.Data = filterData(orig.Data)
+	device := eventDevice(orig)
+	if device == "" {
+		return ""
+	}
+	if routes := eventRoutes(orig); len(routes) > 0 {
+		return fmt.Sprintf(tr("format.subnetForwarding"), device, strings.Join(routes, ", "))
+	}
+	return fmt.Sprintf(tr("format.subnetForwardingNoRoutes"), device)
+}
+
+// formatExitNodeForwarding states which exit node can't forward traffic.
+func formatExitNodeForwarding(orig incomingWebhook) string {
+	orig.Data = filterData(orig.Data)
+	device := eventDevice(orig)
+	if device == "" {
+		return ""
+	}
+	return fmt.Sprintf(tr("format.exitNodeForwarding"), device)
+}
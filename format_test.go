@@ -0,0 +1,220 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// formatTest is an event and the message formatEvent gives it.
+type formatTest struct {
+	name string
+	data map[string]string
+	want string
+}
+
+// runFormatTests checks formatEvent on events of eventType with each
+// test's data. Tests whose want is "" expect the message to be kept.
+func runFormatTests(t *testing.T, eventType string, tests []formatTest) {
+	t.Helper()
+	setTestConfig(t, nil)
+	const orig = "Tailscale's message"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == "" {
+				want = orig
+			}
+			got := formatEvent(incomingWebhook{Type: eventType, Message: orig, Data: tt.data})
+			if got.Message != want {
+				t.Errorf("message = %q, want %q", got.Message, want)
+			}
+		})
+	}
+}
+
+func TestFormatSubnetForwarding(t *testing.T) {
+	runFormatTests(t, "subnetIPForwardingNotEnabled", []formatTest{
+		{
+			name: "routes",
+			data: map[string]string{"nodeID": "n123", "deviceName": "router.example.ts.net", "routes": "10.0.0.0/24,192.168.1.0/24"},
+			want: "router.example.ts.net advertises subnet routes 10.0.0.0/24, 192.168.1.0/24, but IP forwarding is not enabled on it, so they are unreachable",
+		},
+		{
+			name: "advertised routes separated by spaces",
+			data: map[string]string{"hostname": "router", "advertisedRoutes": "10.0.0.0/24 fd7a::/48"},
+			want: "router advertises subnet routes 10.0.0.0/24, fd7a::/48, but IP forwarding is not enabled on it, so they are unreachable",
+		},
+		{
+			name: "node ID only",
+			data: map[string]string{"nodeID": "n123", "subnetRoutes": "10.0.0.0/24"},
+			want: "n123 advertises subnet routes 10.0.0.0/24, but IP forwarding is not enabled on it, so they are unreachable",
+		},
+		{
+			name: "no routes",
+			data: map[string]string{"deviceName": "router.example.ts.net"},
+			want: "router.example.ts.net advertises subnet routes, but IP forwarding is not enabled on it, so they are unreachable",
+		},
+		{
+			name: "no device",
+			data: map[string]string{"routes": "10.0.0.0/24"},
+		},
+		{
+			name: "no data",
+		},
+	})
+}
+
+func TestFormatExitNodeForwarding(t *testing.T) {
+	runFormatTests(t, "exitNodeIPForwardingNotEnabled", []formatTest{
+		{
+			name: "device name",
+			data: map[string]string{"nodeID": "n123", "deviceName": "exit.example.ts.net"},
+			want: "exit.example.ts.net is advertised as an exit node, but IP forwarding is not enabled on it, so traffic through it fails",
+		},
+		{
+			name: "node ID only",
+			data: map[string]string{"nodeID": "n123"},
+			want: "n123 is advertised as an exit node, but IP forwarding is not enabled on it, so traffic through it fails",
+		},
+		{
+			name: "no device",
+			data: map[string]string{"actor": "alice@example.com"},
+		},
+	})
+}
+
+func TestFormatForwardingLocale(t *testing.T) {
+	setTestConfig(t, map[string]string{"LOCALE": "de"})
+	got := formatExitNodeForwarding(incomingWebhook{Data: map[string]string{"deviceName": "exit"}})
+	want := "exit wird als Exit-Node angeboten, aber IP-Forwarding ist dort nicht aktiviert, sodass Verkehr darüber fehlschlägt"
+	if got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+}
+
+func TestFormatDeviceFieldsAreFiltered(t *testing.T) {
+	data := map[string]string{"nodeID": "n123", "deviceName": "router.example.ts.net", "routes": "10.0.0.0/24"}
+	for _, tt := range []struct {
+		env    map[string]string
+		subnet string
+		exit   string
+	}{
+		{
+			map[string]string{"DROP_FIELDS": "deviceName"},
+			"n123 advertises subnet routes 10.0.0.0/24, but IP forwarding is not enabled on it, so they are unreachable",
+			"n123 is advertised as an exit node, but IP forwarding is not enabled on it, so traffic through it fails",
+		},
+		{
+			map[string]string{"DROP_FIELDS": "deviceName,nodeID", "REDACT_FIELDS": "routes"},
+			"",
+			"",
+		},
+		{
+			map[string]string{"REDACT_FIELDS": "deviceName,routes"},
+			"[redacted] advertises subnet routes [redacted], but IP forwarding is not enabled on it, so they are unreachable",
+			"[redacted] is advertised as an exit node, but IP forwarding is not enabled on it, so traffic through it fails",
+		},
+	} {
+		setTestConfig(t, tt.env)
+		orig := incomingWebhook{Data: data}
+		if got := formatSubnetForwarding(orig); got != tt.subnet {
+			t.Errorf("%v: formatSubnetForwarding = %q, want %q", tt.env, got, tt.subnet)
+		}
+		if got := formatExitNodeForwarding(orig); got != tt.exit {
+			t.Errorf("%v: formatExitNodeForwarding = %q, want %q", tt.env, got, tt.exit)
+		}
+		if got := formatEvent(incomingWebhook{Type: "subnetIPForwardingNotEnabled", Data: data}).Message; strings.Contains(got, "router.example") {
+			t.Errorf("%v: message %q has the device name", tt.env, got)
+		}
+	}
+}
+
+func TestFormatRoleUpdate(t *testing.T) {
+	runFormatTests(t, "userRoleUpdated", []formatTest{
+		{
+			name: "old and new role",
+			data: map[string]string{"actor": "alice@example.com", "user": "bob@example.com", "oldRole": "member", "newRole": "admin"},
+			want: "alice@example.com changed the role of bob@example.com from member to admin",
+		},
+		{
+			name: "alternate keys",
+			data: map[string]string{"actor": "alice@example.com", "loginName": "bob@example.com", "previousRole": "admin", "role": "auditor"},
+			want: "alice@example.com changed the role of bob@example.com from admin to auditor",
+		},
+		{
+			name: "no old role",
+			data: map[string]string{"actor": "alice@example.com", "userName": "bob@example.com", "newRoles": "it-admin"},
+			want: "alice@example.com changed the role of bob@example.com to it-admin",
+		},
+		{
+			name: "no actor",
+			data: map[string]string{"target": "bob@example.com", "oldRole": "member", "newRole": "owner"},
+			want: "Someone changed the role of bob@example.com from member to owner",
+		},
+		{
+			name: "no new role",
+			data: map[string]string{"actor": "alice@example.com", "user": "bob@example.com", "oldRole": "member"},
+		},
+		{
+			name: "no user",
+			data: map[string]string{"actor": "alice@example.com", "newRole": "admin"},
+		},
+	})
+}
+
+func TestFormatUserAction(t *testing.T) {
+	data := map[string]string{"actor": "alice@example.com", "user": "bob@example.com"}
+	for eventType, want := range map[string]string{
+		"userApproved":  "alice@example.com approved bob@example.com",
+		"userSuspended": "alice@example.com suspended bob@example.com",
+		"userRestored":  "alice@example.com restored bob@example.com",
+		"userDeleted":   "alice@example.com deleted bob@example.com",
+	} {
+		t.Run(eventType, func(t *testing.T) {
+			runFormatTests(t, eventType, []formatTest{
+				{name: "actor and user", data: data, want: want},
+				{name: "no actor", data: map[string]string{"user": "bob@example.com"}},
+				{name: "no user", data: map[string]string{"actor": "alice@example.com"}},
+				{name: "no data"},
+			})
+		})
+	}
+}
+
+func TestFormatUserFieldsAreFiltered(t *testing.T) {
+	data := map[string]string{"actor": "alice@example.com", "user": "bob@example.com", "oldRole": "member", "newRole": "admin"}
+	for _, tt := range []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"REDACT_FIELDS": "actor"}, "[redacted] changed the role of bob@example.com from member to admin"},
+		{map[string]string{"DROP_FIELDS": "actor"}, "Someone changed the role of bob@example.com from member to admin"},
+		{map[string]string{"INCLUDE_FIELDS": "user,newRole"}, "Someone changed the role of bob@example.com to admin"},
+	} {
+		setTestConfig(t, tt.env)
+		for _, eventType := range []string{"userRoleUpdated", "userSuspended"} {
+			got := formatEvent(incomingWebhook{Type: eventType, Message: "Tailscale's message", Data: data}).Message
+			if strings.Contains(got, "alice") {
+				t.Errorf("%v: %s message %q has the actor", tt.env, eventType, got)
+			}
+			if eventType == "userRoleUpdated" && got != tt.want {
+				t.Errorf("%v: message = %q, want %q", tt.env, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestAdminEventsAreLinkedAndSevere(t *testing.T) {
+	setTestConfig(t, nil)
+	if got := eventSeverity("userRoleUpdated"); got != severityCritical {
+		t.Errorf("userRoleUpdated severity = %v, want critical", got)
+	}
+	orig := incomingWebhook{Type: "userRoleUpdated", Data: map[string]string{"user": "bob@example.com"}}
+	if got := adminConsoleURL(orig); got == "" {
+		t.Error("userRoleUpdated has no admin console link")
+	}
+}
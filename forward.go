@@ -0,0 +1,61 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// forwardHopsHeader counts the adapters a batch has been forwarded
+// through, so that a forwarding loop between instances ends.
+const forwardHopsHeader = "X-Ts-Webhook-Adapter-Hops"
+
+// forwardBatch forwards the events, as received and before any filtering,
+// to the secondary adapter at FORWARD_URL, so that it can apply its own
+// configuration. hops is the forwardHopsHeader of the incoming request;
+// batches that have already been forwarded FORWARD_MAX_HOPS times are not
+// forwarded again.
+//
+// The batch is signed like Tailscale does with FORWARD_SECRET, or with
+// TS_WEBHOOK_SECRET if that is not set, or sent unsigned if neither is.
+func forwardBatch(c ForwardConfig, client *http.Client, events []incomingWebhook, hops int) {
+	if c.URL == "" {
+		// not configured
+		return
+	}
+	if hops >= c.MaxHops {
+		debugf("forwardBatch not forwarding %d events, already forwarded %d times", len(events), hops)
+		return
+	}
+
+	body, err := json.Marshal(events)
+	if err != nil {
+		log.Printf("forwardBatch json.Marshal failed: %v", err)
+		return
+	}
+	header := http.Header{forwardHopsHeader: {strconv.Itoa(hops + 1)}}
+	sum := sha256.Sum256(body)
+	setIdempotencyKey(header, hex.EncodeToString(sum[:]))
+	if cfg.Gzip["forward"] && len(body) >= cfg.GzipMinBytes {
+		// The signature covers the body as sent, so it is compressed
+		// here rather than by doRequest.
+		if body, err = gzipBody(body); err != nil {
+			log.Printf("forwardBatch gzipBody failed: %v", err)
+			return
+		}
+		header.Set("Content-Encoding", "gzip")
+	}
+	if c.Secret != "" {
+		header.Set(defaultSignatureHeader, signWebhook(body, c.Secret))
+	}
+	if _, err := deliver(outboundRequest{dest: "forward", client: client, url: c.URL, header: header, body: body}); err != nil {
+		log.Printf("forwardBatch deliver failed: %v", err)
+	}
+}
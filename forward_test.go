@@ -0,0 +1,51 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestForwardBatchVerifies(t *testing.T) {
+	for _, gzip := range []string{"false", "true"} {
+		t.Run("gzip="+gzip, func(t *testing.T) {
+			c := setTestConfig(t, map[string]string{
+				"TS_WEBHOOK_SECRET":  "secret",
+				"FORWARD_GZIP":       gzip,
+				"GZIP_MIN_BYTES":     "0",
+				"RETRY_MAX_ATTEMPTS": "1",
+			})
+			got := make(chan []incomingWebhook, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if compressed := r.Header.Get("Content-Encoding") == "gzip"; compressed != (gzip == "true") {
+					t.Errorf("Content-Encoding = %q", r.Header.Get("Content-Encoding"))
+				}
+				events, err := verifyWebhookSignature(r, "secret")
+				if err != nil {
+					t.Errorf("verifyWebhookSignature: %v", err)
+					writeError(w, http.StatusUnauthorized)
+					return
+				}
+				got <- events
+			}))
+			defer srv.Close()
+			c.Forward.URL = srv.URL
+
+			events := []incomingWebhook{{Type: "nodeCreated", Message: strings.Repeat("a new node ", 100)}}
+			forwardBatch(c.Forward, srv.Client(), events, 0)
+			select {
+			case e := <-got:
+				if len(e) != 1 || e[0].Message != events[0].Message {
+					t.Errorf("forwarded events = %+v", e)
+				}
+			default:
+				t.Fatal("no batch forwarded")
+			}
+		})
+	}
+}
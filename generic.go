@@ -0,0 +1,107 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// sendGenericWebhook posts the event to an arbitrary URL, either as the
+// original JSON event or rendered through a payload template. The
+// IDEMPOTENCY_HEADER carries the event's hash, which stays the same
+// across retries and repeated deliveries of the event.
+func sendGenericWebhook(c GenericConfig, client *http.Client, orig incomingWebhook) error {
+	webhookUrl := c.URL
+	if webhookUrl == "" {
+		// not configured
+		return nil
+	}
+
+	key := eventHash(orig)
+	orig.Data = filterData(orig.Data)
+
+	var body []byte
+	var err error
+	if tmpl := c.TemplateFile; tmpl != "" {
+		body, err = renderPayloadTemplate(tmpl, orig)
+		if err == nil && cfg.PrettyJSON {
+			buf := new(bytes.Buffer)
+			if err = json.Indent(buf, body, "", "  "); err == nil {
+				body = buf.Bytes()
+			}
+		}
+	} else {
+		body, err = marshalEvent(orig)
+	}
+	if err != nil {
+		log.Printf("sendGenericWebhook building payload failed: %v", err)
+		return err
+	}
+
+	header := c.Headers.Clone()
+	setIdempotencyKey(header, key)
+	if _, err := deliver(outboundRequest{dest: "generic", client: client, url: webhookUrl, header: header, body: body}); err != nil {
+		log.Printf("sendGenericWebhook deliver failed: %v", err)
+		return err
+	}
+	return nil
+}
+
+// setIdempotencyKey sets the IDEMPOTENCY_HEADER, unless it is "none" or
+// already set, e.g. through GENERIC_WEBHOOK_HEADERS.
+func setIdempotencyKey(header http.Header, key string) {
+	name := cfg.IdempotencyHeader
+	if name == "none" || header.Get(name) != "" {
+		return
+	}
+	header.Set(name, key)
+}
+
+// marshalEvent encodes v as JSON, indented if PRETTY_JSON is set. Only
+// the destinations that carry whole events, rather than chat messages,
+// honor PRETTY_JSON.
+func marshalEvent(v any) ([]byte, error) {
+	if cfg.PrettyJSON {
+		return json.MarshalIndent(v, "", "  ")
+	}
+	return json.Marshal(v)
+}
+
+// parseHeaders parses extra request headers, given either as a JSON object
+// such as {"Authorization": "Bearer xyz"}, or as "Key: value" pairs
+// separated by newlines or semicolons.
+func parseHeaders(s string) (http.Header, error) {
+	header := make(http.Header)
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return header, nil
+	}
+	if strings.HasPrefix(s, "{") {
+		var m map[string]string
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			return nil, err
+		}
+		for k, v := range m {
+			header.Add(k, v)
+		}
+		return header, nil
+	}
+	for _, line := range strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ';' }) {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid header %q, want \"Key: value\"", line)
+		}
+		header.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+	return header, nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// destinationHealth records recent delivery results for a destination.
+type destinationHealth struct {
+	LastSuccess         time.Time `json:"lastSuccess,omitzero"`
+	LastFailure         time.Time `json:"lastFailure,omitzero"`
+	LastError           string    `json:"lastError,omitempty"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+}
+
+var (
+	healthMu sync.Mutex
+	health   = map[string]*destinationHealth{}
+)
+
+// recordHealth records the outcome of a delivery for /readyz.
+func recordHealth(r DeliveryResult) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	h := health[r.Destination]
+	if h == nil {
+		h = new(destinationHealth)
+		health[r.Destination] = h
+	}
+	if r.Success {
+		h.LastSuccess = time.Now()
+		h.ConsecutiveFailures = 0
+		return
+	}
+	h.LastFailure = time.Now()
+	h.LastError = r.Err.Error()
+	h.ConsecutiveFailures++
+}
+
+type readiness struct {
+	Status       string                       `json:"status"`
+	Destinations map[string]destinationHealth `json:"destinations"`
+}
+
+// checkReadiness reports the adapter as degraded if every delivery to
+// every destination within HEALTH_WINDOW has failed, and as not ready if
+// no destinations are configured at all or STARTUP_DELAY hasn't passed.
+func checkReadiness() readiness {
+	since := time.Now().Add(-cfg.HealthWindow)
+
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	r := readiness{
+		Status:       "ok",
+		Destinations: make(map[string]destinationHealth, len(health)),
+	}
+	var failing, succeeding int
+	for dest, h := range health {
+		r.Destinations[dest] = *h
+		if h.LastSuccess.After(since) {
+			succeeding++
+		} else if h.LastFailure.After(since) {
+			failing++
+		}
+	}
+	if failing > 0 && succeeding == 0 {
+		r.Status = "degraded"
+	}
+	if warmingUp() {
+		r.Status = "starting"
+	}
+	if len(configuredDestinations()) == 0 {
+		r.Status = "no_destinations"
+	}
+	return r
+}
+
+// handleHealthz reports that the process is up, as {"status": "ok"}.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports recent delivery health, with a 503 status when
+// nothing is getting through.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ready := checkReadiness()
+	status := http.StatusOK
+	if ready.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, ready)
+}
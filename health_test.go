@@ -0,0 +1,39 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthEndpointsAreJSON(t *testing.T) {
+	setTestConfig(t, nil)
+	for path, handler := range map[string]http.HandlerFunc{
+		"/healthz": handleHealthz,
+		"/readyz":  handleReadyz,
+	} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if got := w.Header().Get("Content-Type"); got != jsonContentType {
+			t.Errorf("%s Content-Type = %q, want %q", path, got, jsonContentType)
+		}
+		if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("%s X-Content-Type-Options = %q, want nosniff", path, got)
+		}
+		var body struct{ Status string }
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Status == "" {
+			t.Errorf("%s body = %s (%v), want a JSON status", path, w.Body, err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleHealthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "{\n  \"status\": \"ok\"\n}\n" {
+		t.Errorf("/healthz = %d %q", w.Code, w.Body)
+	}
+}
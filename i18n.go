@@ -0,0 +1,170 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// catalogs holds the translations of the adapter's own message text by
+// locale. Event content from Tailscale is never translated. To add a
+// language, add a map here; missing keys fall back to English.
+var catalogs = map[string]map[string]string{
+	"en": {
+		"viewInAdminConsole": "View in Admin Console",
+		"openRunbook":        "Open Runbook",
+		"showRawEvent":       "Show raw event",
+		"eventsSummary":      "%d × %s",
+		"digestSummary":      "Digest: %d events since %s",
+		"floodSummary":       "%d more events suppressed",
+		"unknownType":        "Unrecognized event type",
+		"resolved":           "Resolved",
+		"resolvedBy":         "Resolved by %s",
+		"expiresIn":          "expires in %s",
+		"expiredAgo":         "expired %s ago",
+		"moreFields":         "+%d more",
+		"yes":                "Yes",
+		"no":                 "No",
+		"severity":           "Severity",
+		"severity.info":      "Info",
+		"severity.warning":   "Warning",
+		"severity.critical":  "Critical",
+
+		"format.subnetForwarding":         "%s advertises subnet routes %s, but IP forwarding is not enabled on it, so they are unreachable",
+		"format.subnetForwardingNoRoutes": "%s advertises subnet routes, but IP forwarding is not enabled on it, so they are unreachable",
+		"format.exitNodeForwarding":       "%s is advertised as an exit node, but IP forwarding is not enabled on it, so traffic through it fails",
+		"format.roleUpdated":              "%s changed the role of %s from %s to %s",
+		"format.roleUpdatedTo":            "%s changed the role of %s to %s",
+		"format.policyUpdated":            "%s updated the tailnet policy file",
+		"format.userApproved":             "%s approved %s",
+		"format.userSuspended":            "%s suspended %s",
+		"format.userRestored":             "%s restored %s",
+		"format.userDeleted":              "%s deleted %s",
+		"someone":                         "Someone",
+
+		"field.nodeID":     "Node ID",
+		"field.deviceName": "Device",
+		"field.hostname":   "Hostname",
+		"field.managedBy":  "Managed by",
+		"field.actor":      "Actor",
+		"field.user":       "User",
+		"field.url":        "Link",
+		"field.os":         "OS",
+		"field.addresses":  "Addresses",
+		"field.tags":       "Tags",
+		"field.routes":     "Routes",
+		"field.oldRole":    "Previous role",
+		"field.newRole":    "New role",
+		"field.count":      "Events",
+		"field.devices":    "Devices",
+		"field.first":      "First",
+		"field.last":       "Last",
+	},
+	"de": {
+		"viewInAdminConsole": "In der Admin-Konsole anzeigen",
+		"openRunbook":        "Runbook öffnen",
+		"showRawEvent":       "Rohdaten anzeigen",
+		"eventsSummary":      "%d × %s",
+		"digestSummary":      "Zusammenfassung: %d Ereignisse seit %s",
+		"floodSummary":       "%d weitere Ereignisse unterdrückt",
+		"unknownType":        "Unbekannter Ereignistyp",
+		"resolved":           "Erledigt",
+		"resolvedBy":         "Erledigt durch %s",
+		"expiresIn":          "läuft in %s ab",
+		"expiredAgo":         "vor %s abgelaufen",
+		"moreFields":         "+%d weitere",
+		"yes":                "Ja",
+		"no":                 "Nein",
+		"severity":           "Schweregrad",
+		"severity.info":      "Info",
+		"severity.warning":   "Warnung",
+		"severity.critical":  "Kritisch",
+
+		"format.subnetForwarding":         "%s bietet die Subnetz-Routen %s an, aber IP-Forwarding ist dort nicht aktiviert, sodass sie nicht erreichbar sind",
+		"format.subnetForwardingNoRoutes": "%s bietet Subnetz-Routen an, aber IP-Forwarding ist dort nicht aktiviert, sodass sie nicht erreichbar sind",
+		"format.exitNodeForwarding":       "%s wird als Exit-Node angeboten, aber IP-Forwarding ist dort nicht aktiviert, sodass Verkehr darüber fehlschlägt",
+		"format.roleUpdated":              "%s hat die Rolle von %s von %s zu %s geändert",
+		"format.roleUpdatedTo":            "%s hat die Rolle von %s zu %s geändert",
+		"format.policyUpdated":            "%s hat die Tailnet-Richtliniendatei geändert",
+		"format.userApproved":             "%s hat %s freigegeben",
+		"format.userSuspended":            "%s hat %s gesperrt",
+		"format.userRestored":             "%s hat %s wiederhergestellt",
+		"format.userDeleted":              "%s hat %s gelöscht",
+		"someone":                         "Jemand",
+
+		"field.nodeID":     "Knoten-ID",
+		"field.deviceName": "Gerät",
+		"field.hostname":   "Hostname",
+		"field.managedBy":  "Verwaltet von",
+		"field.actor":      "Ausgeführt von",
+		"field.user":       "Benutzer",
+		"field.url":        "Link",
+		"field.os":         "Betriebssystem",
+		"field.addresses":  "Adressen",
+		"field.tags":       "Tags",
+		"field.routes":     "Routen",
+		"field.oldRole":    "Bisherige Rolle",
+		"field.newRole":    "Neue Rolle",
+		"field.count":      "Ereignisse",
+		"field.devices":    "Geräte",
+		"field.first":      "Erstes",
+		"field.last":       "Letztes",
+	},
+}
+
+// parseLocale validates a LOCALE such as "de" or "de-AT", reporting the
+// supported catalog to use for it.
+func parseLocale(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return "en", nil
+	}
+	if _, ok := catalogs[s]; ok {
+		return s, nil
+	}
+	if lang, _, ok := strings.Cut(strings.ReplaceAll(s, "_", "-"), "-"); ok {
+		if _, ok := catalogs[lang]; ok {
+			return lang, nil
+		}
+	}
+	supported := make([]string, 0, len(catalogs))
+	for l := range catalogs {
+		supported = append(supported, l)
+	}
+	sort.Strings(supported)
+	return "", fmt.Errorf("unsupported locale %q, want one of %s", s, strings.Join(supported, ", "))
+}
+
+// tr translates a message key into the configured locale.
+func tr(key string) string {
+	if s, ok := catalogs[cfg.Locale][key]; ok {
+		return s
+	}
+	if s, ok := catalogs["en"][key]; ok {
+		return s
+	}
+	return key
+}
+
+// fieldLabel reports the translated label for a data field, or the
+// key itself for fields without one.
+func fieldLabel(key string) string {
+	if s := tr("field." + key); s != "field."+key {
+		return s
+	}
+	return key
+}
+
+// eventLabel reports the human-friendly label for an event type set in
+// TYPE_LABELS, e.g. "nodeKeyExpiringInOneDay=Key expiring soon", or the
+// raw type if it has none.
+func eventLabel(eventType string) string {
+	if label := cfg.TypeLabels[eventType]; label != "" {
+		return label
+	}
+	return eventType
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// publicBaseURL reports the external URL of the adapter, for links it
+// generates to itself. PUBLIC_BASE_URL takes precedence; otherwise, when
+// TRUST_PROXY is set, the scheme and host forwarded by a reverse proxy
+// are used, and the request's own are used as a last resort.
+func publicBaseURL(r *http.Request) string {
+	if u := cfg.PublicBaseURL; u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if cfg.TrustProxy {
+		if p := r.Header.Get("X-Forwarded-Proto"); p != "" {
+			scheme, _, _ = strings.Cut(p, ",")
+		}
+		if h := r.Header.Get("X-Forwarded-Host"); h != "" {
+			host, _, _ = strings.Cut(h, ",")
+		}
+	}
+	return strings.TrimSpace(scheme) + "://" + strings.TrimSpace(host)
+}
+
+// endpoints are the paths listed by handleIndex.
+var endpoints = []string{"/webhook", "/version", "/healthz", "/readyz", "/metrics", "/stats"}
+
+// handleIndex lists the adapter's endpoints.
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+	base := publicBaseURL(r)
+	links := make(map[string]string, len(endpoints))
+	for _, p := range endpoints {
+		links[strings.TrimPrefix(p, "/")] = base + p
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"endpoints": links})
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// inflight holds a token for each batch being processed, of at most
+// MAX_INFLIGHT_BATCHES. It is nil if there is no limit.
+var inflight chan struct{}
+
+// limitInflight responds to webhooks beyond MAX_INFLIGHT_BATCHES being
+// processed at once with a 503 status, so that Tailscale backs off and a
+// flood of requests can't pile up goroutines and memory without bound.
+func limitInflight(max int, h http.HandlerFunc) http.HandlerFunc {
+	if max <= 0 {
+		return h
+	}
+	inflight = make(chan struct{}, max)
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case inflight <- struct{}{}:
+		default:
+			log.Printf("WARNING: rejecting webhook, %d batches already being processed (MAX_INFLIGHT_BATCHES)", max)
+			webhooksRejected.WithLabelValues("busy").Inc()
+			w.Header().Set("Retry-After", "5")
+			writeError(w, http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-inflight }()
+		h(w, r)
+	}
+}
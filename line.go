@@ -0,0 +1,68 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// https://developers.line.biz/en/reference/messaging-api/#send-push-message
+const linePushURL = "https://api.line.me/v2/bot/message/push"
+
+type linePushMessage struct {
+	To       string        `json:"to"`
+	Messages []lineMessage `json:"messages"`
+}
+
+type lineMessage struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// sendLineMessage pushes the event as a text message to the user, group or
+// room LINE_TO, as the LINE Official Account that
+// LINE_CHANNEL_ACCESS_TOKEN belongs to.
+func sendLineMessage(c LineConfig, client *http.Client, orig incomingWebhook) error {
+	if c.Token == "" || c.To == "" {
+		// not configured
+		return nil
+	}
+
+	data := displayData("line", orig)
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "%s\n%s", displayLabel("line", orig), displayMessage("line", orig))
+	for _, k := range fieldKeys(data) {
+		fmt.Fprintf(buf, "\n%s: %s", fieldLabel(k), data[k])
+	}
+
+	err := deliverParts("line", messageParts(buf.String(), c.MaxLength), func(part string) (outboundRequest, error) {
+		body, err := json.Marshal(linePushMessage{
+			To:       c.To,
+			Messages: []lineMessage{{Type: "text", Text: part}},
+		})
+		return outboundRequest{
+			dest:   "line",
+			client: client,
+			url:    linePushURL,
+			header: http.Header{
+				"Authorization": {"Bearer " + c.Token},
+				// LINE accepts a retried push with the same key only once.
+				"X-Line-Retry-Key": {uuid.NewString()},
+			},
+			body: body,
+		}, err
+	})
+	if err != nil {
+		log.Printf("sendLineMessage deliver failed: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSendLineMessage(t *testing.T) {
+	c := setTestConfig(t, map[string]string{
+		"LINE_CHANNEL_ACCESS_TOKEN": "token",
+		"LINE_TO":                   "U0123456789abcdef",
+	})
+	stub := newStubDestination(t, `{}`)
+	err := sendLineMessage(c.Line, stub.client, incomingWebhook{
+		Type:    "nodeCreated",
+		Message: "Node created",
+		Data:    map[string]string{"nodeID": "n1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := stub.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d requests, want 1", len(sent))
+	}
+	req := sent[0]
+	if got := req.URL.String(); got != linePushURL {
+		t.Errorf("URL = %s, want %s", got, linePushURL)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if req.Header.Get("X-Line-Retry-Key") == "" {
+		t.Error("no X-Line-Retry-Key")
+	}
+	var msg linePushMessage
+	if err := json.Unmarshal(req.Body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.To != "U0123456789abcdef" || len(msg.Messages) != 1 || msg.Messages[0].Type != "text" {
+		t.Fatalf("message = %+v", msg)
+	}
+	if text := msg.Messages[0].Text; !strings.Contains(text, "Node created") || !strings.Contains(text, "n1") {
+		t.Errorf("text = %q, want the message and its fields", text)
+	}
+}
+
+func TestLineNotifyTokenIsRejected(t *testing.T) {
+	_, err := newConfig(func(name string) (string, bool) {
+		if name == "LINE_NOTIFY_TOKEN" {
+			return "token", true
+		}
+		return "", false
+	})
+	if err == nil || !strings.Contains(err.Error(), "LINE_CHANNEL_ACCESS_TOKEN") {
+		t.Errorf("newConfig = %v, want an error pointing to LINE_CHANNEL_ACCESS_TOKEN", err)
+	}
+}
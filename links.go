@@ -0,0 +1,59 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Default admin console link templates, matching login.tailscale.com.
+// Placeholders are expanded by expandPlaceholders.
+const (
+	defaultAdminBaseURL    = "https://login.tailscale.com/admin"
+	defaultAdminDevicePath = "/machines"
+	defaultAdminUserPath   = "/users"
+	defaultAdminPolicyPath = "/acls"
+)
+
+// adminConsoleURL reports a link to the device or user an event is about
+// in the admin console, or "" if the event is about neither.
+//
+// The links are built from ADMIN_BASE_URL and the ADMIN_DEVICE_PATH,
+// ADMIN_USER_PATH and ADMIN_POLICY_PATH templates, so that they can point
+// at Headscale or another control plane, e.g.
+// ADMIN_DEVICE_PATH=/web/devices.html?id={nodeID}.
+func adminConsoleURL(orig incomingWebhook) string {
+	admin := cfg.Admin
+
+	// Tailscale includes a link to the affected device or user for most
+	// events, which is more specific than the defaults.
+	if u := orig.Data["url"]; !admin.Customized && strings.HasPrefix(u, "https://") {
+		return u
+	}
+
+	var path string
+	switch {
+	case strings.HasPrefix(orig.Type, "node"),
+		strings.HasPrefix(orig.Type, "subnet"),
+		strings.HasPrefix(orig.Type, "exitNode"):
+		path = admin.DevicePath
+	case strings.HasPrefix(orig.Type, "user"):
+		path = admin.UserPath
+	case orig.Type == "policyUpdate":
+		path = admin.PolicyPath
+	default:
+		return ""
+	}
+	return admin.BaseURL + expandURLPlaceholders(path, orig)
+}
+
+// expandURLPlaceholders is like expandPlaceholders, but escapes the
+// values for use in a URL.
+func expandURLPlaceholders(s string, orig incomingWebhook) string {
+	return placeholderRE.ReplaceAllStringFunc(s, func(m string) string {
+		return url.QueryEscape(expandPlaceholders(m, orig))
+	})
+}
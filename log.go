@@ -0,0 +1,37 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"log/slog"
+)
+
+// configureLogging attaches INSTANCE_NAME and ENVIRONMENT, where set, to
+// every log line and event record, so that those of different replicas
+// and environments can be told apart.
+func configureLogging(c *Config) {
+	var prefix string
+	var attrs []any
+	for _, f := range []struct{ key, value string }{
+		{"instance", c.InstanceName},
+		{"environment", c.Environment},
+	} {
+		if f.value != "" {
+			prefix += f.key + "=" + f.value + " "
+			attrs = append(attrs, slog.String(f.key, f.value))
+		}
+	}
+	log.SetFlags(log.LstdFlags | log.Lmsgprefix)
+	log.SetPrefix(prefix)
+	eventLogger = eventLogger.With(attrs...)
+}
+
+// debugf logs only when debug logging is enabled by LOG_LEVEL=debug.
+func debugf(format string, args ...any) {
+	if cfg.Debug {
+		log.Printf("DEBUG "+format, args...)
+	}
+}
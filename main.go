@@ -6,14 +6,24 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/google/uuid"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
-	"github.com/google/uuid"
+	"unicode/utf8"
 )
 
 type incomingWebhook struct {
@@ -23,185 +33,817 @@ type incomingWebhook struct {
 	Tailnet   string            `json:"tailnet"`
 	Message   string            `json:"message"`
 	Data      map[string]string `json:"data"`
+
+	// received is the eventHash of the event as it was received, before
+	// dispatch transformed and formatted it ("" for events made by the
+	// adapter, such as digests). It is not sent on.
+	received string
+}
+
+// rawEventJSON renders the event, with its data filtered, as indented JSON.
+func rawEventJSON(orig incomingWebhook) string {
+	orig.Data = filterData(orig.Data)
+	b, _ := json.MarshalIndent(orig, "", "  ")
+	return string(b)
+}
+
+// eventHash reports a stable hex-encoded SHA-256 hash of the event, suitable
+// for deduplicating deliveries of the same event.
+func eventHash(orig incomingWebhook) string {
+	// encoding/json sorts map keys, so the encoding is deterministic.
+	b, _ := json.Marshal(orig)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
 }
 
 // https://learn.microsoft.com/en-us/outlook/actionable-messages/message-card-reference
 type teamsWebhook struct {
-    Type          string        `json:"@type"`
-    Context       string        `json:"@context"`
-    CorrelationId string        `json:"correlationId"`
-    Text          string        `json:"text"`
-    Summary       string        `json:"summary"`
-    ThemeColor    string        `json:"themeColor"`
-    Title         string        `json:"title"`
-    Attachments   []attachment  `json:"attachments"`
+	Type          string        `json:"@type"`
+	Context       string        `json:"@context"`
+	CorrelationId string        `json:"correlationId"`
+	Text          string        `json:"text"`
+	Summary       string        `json:"summary"`
+	ThemeColor    string        `json:"themeColor"`
+	Title         string        `json:"title"`
+	Attachments   []attachment  `json:"attachments"`
+	Actions       []teamsAction `json:"potentialAction,omitempty"`
+}
+
+// https://learn.microsoft.com/en-us/outlook/actionable-messages/message-card-reference#openuri-action
+type teamsAction struct {
+	Type    string        `json:"@type"`
+	Name    string        `json:"name"`
+	Targets []teamsTarget `json:"targets"`
+}
+
+type teamsTarget struct {
+	OS  string `json:"os"`
+	URI string `json:"uri"`
+}
+
+func openURIAction(name, uri string) teamsAction {
+	return teamsAction{
+		Type:    "OpenUri",
+		Name:    name,
+		Targets: []teamsTarget{{OS: "default", URI: uri}},
+	}
 }
 
 type attachment struct {
-    ContentType string                 `json:"contentType"`
-    Content     map[string]interface{} `json:"content"`
-}
-
-func sendTeamsWebhook(orig incomingWebhook) {
-    webhookUrl := os.Getenv("TEAMS_WEBHOOK_URL")
-    if webhookUrl == "" {
-        return
-    }
-
-    // Create the adaptive card content
-    content := map[string]interface{}{
-        "type": "AdaptiveCard",
-        "body": []map[string]interface{}{
-            {
-                "type": "TextBlock",
-                "size": "Medium",
-                "weight": "Bolder",
-                "text": orig.Message,
-            },
-            {
-                "type": "FactSet",
-                "facts": createFacts(orig.Data),
-            },
-        },
-        "$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
-        "version": "1.2",
-    }
-
-    teams := teamsWebhook{
-        Type:          "MessageCard",
-        Context:       "https://schema.org/extensions",
-        CorrelationId: uuid.NewString(),
-        Summary:       orig.Message,
-        ThemeColor:    "0078D7", // Microsoft blue
-        Title:         orig.Message,
-        Attachments: []attachment{
-            {
-                ContentType: "application/vnd.microsoft.card.adaptive",
-                Content:     content,
-            },
-        },
-    }
-
-    body, err := json.Marshal(teams)
-    if err != nil {
-        log.Printf("[%s] sendTeamsWebhook json.Marshal failed: %v", time.Now().Format(time.RFC3339), err)
-        return
-    }
-
-    // Add the missing HTTP request code
-    req, err := http.NewRequest(http.MethodPost, webhookUrl, bytes.NewBuffer(body))
-    if err != nil {
-        log.Printf("[%s] sendTeamsWebhook http.NewRequest failed: %v", time.Now().Format(time.RFC3339), err)
-        return
-    }
-
-    req.Header.Add("Content-Type", "application/json")
-
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        log.Printf("[%s] sendTeamsWebhook client.Do failed: %v", time.Now().Format(time.RFC3339), err)
-        return
-    }
-    defer resp.Body.Close()
-}
-
-func createFacts(data map[string]string) []map[string]string {
-    facts := make([]map[string]string, 0, len(data))
-    for k, v := range data {
-        facts = append(facts, map[string]string{
-            "title": k,
-            "value": v,
-        })
-    }
-    return facts
+	ContentType string `json:"contentType"`
+	Content     any    `json:"content"` // an adaptiveCard, or a decoded TEAMS_CARD_TEMPLATE_FILE
+}
+
+// The built-in card is made of structs rather than maps, as it is built
+// for every event.
+// https://adaptivecards.io/explorer/AdaptiveCard.html
+type adaptiveCard struct {
+	Type    string `json:"type"`
+	Body    []any  `json:"body"`
+	Actions []any  `json:"actions,omitempty"`
+	Schema  string `json:"$schema"`
+	Version string `json:"version"`
+}
+
+// https://adaptivecards.io/explorer/TextBlock.html
+type teamsTextBlock struct {
+	Type      string `json:"type"`
+	ID        string `json:"id,omitempty"`
+	IsVisible *bool  `json:"isVisible,omitempty"`
+	Size      string `json:"size,omitempty"`
+	Weight    string `json:"weight,omitempty"`
+	IsSubtle  bool   `json:"isSubtle,omitempty"`
+	Spacing   string `json:"spacing,omitempty"`
+	Wrap      bool   `json:"wrap,omitempty"`
+	FontType  string `json:"fontType,omitempty"`
+	Text      string `json:"text"`
+}
+
+type teamsFactSet struct {
+	Type  string      `json:"type"`
+	Facts []teamsFact `json:"facts"`
+}
+
+// https://adaptivecards.io/explorer/Action.ToggleVisibility.html
+type teamsToggleAction struct {
+	Type           string   `json:"type"`
+	Title          string   `json:"title"`
+	TargetElements []string `json:"targetElements"`
+}
+
+// teamsCardData is what a TEAMS_CARD_TEMPLATE_FILE is executed with: the
+// event, with its data filtered, and what the built-in card shows of it.
+type teamsCardData struct {
+	incomingWebhook
+	Label    string
+	Severity string
+	Color    string // e.g. "D13438"
+	AdminURL string
+	Diff     string // of the policy file, for policyUpdate events
+}
+
+func newTeamsCardData(orig incomingWebhook) teamsCardData {
+	d := teamsCardData{
+		incomingWebhook: orig,
+		Label:           displayLabel("teams", orig),
+		Severity:        severityOf(orig).String(),
+		Color:           themeColor(orig),
+		AdminURL:        displayLink("teams", orig),
+		Diff:            displayDiff("teams", orig),
+	}
+	d.Data = filterData(orig.Data)
+	return d
+}
+
+// sendTeamsWebhook posts the event to Teams as an Adaptive Card, either
+// the built-in one or that rendered from TEAMS_CARD_TEMPLATE_FILE.
+func sendTeamsWebhook(c TeamsConfig, client *http.Client, orig incomingWebhook) error {
+	webhookUrl := c.WebhookURL
+	if webhookUrl == "" {
+		return nil
+	}
+
+	// Create the adaptive card content
+	card := adaptiveCard{
+		Type: "AdaptiveCard",
+		Body: []any{
+			teamsTextBlock{
+				Type:   "TextBlock",
+				Size:   "Medium",
+				Weight: "Bolder",
+				Text:   displayMessage("teams", orig),
+			},
+			teamsTextBlock{
+				Type:     "TextBlock",
+				IsSubtle: true,
+				Spacing:  "None",
+				Text:     tr("severity") + ": " + tr("severity."+severityOf(orig).String()),
+			},
+		},
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Version: "1.2",
+	}
+	if data := displayData("teams", orig); len(data) > 0 {
+		card.Body = append(card.Body, teamsFactSet{Type: "FactSet", Facts: createFacts(data, c.MaxFacts)})
+	}
+	if diff := displayDiff("teams", orig); diff != "" {
+		card.Body = append(card.Body, teamsTextBlock{
+			Type:     "TextBlock",
+			Wrap:     true,
+			FontType: "Monospace",
+			Text:     truncateForLimit(diff, policyDiffLimit),
+		})
+	}
+
+	var content any = card
+	if tmpl := c.CardTemplateFile; tmpl != "" {
+		rendered, err := renderPayloadTemplate(tmpl, newTeamsCardData(orig))
+		if err == nil {
+			var m map[string]any
+			err = json.Unmarshal(rendered, &m)
+			content = m
+		}
+		if err != nil {
+			log.Printf("sendTeamsWebhook rendering TEAMS_CARD_TEMPLATE_FILE failed: %v", err)
+			return err
+		}
+	} else if displayRawJSON("teams") {
+		// Collapsed, with a button to reveal it.
+		hidden := false
+		card.Body = append(card.Body, teamsTextBlock{
+			Type:      "TextBlock",
+			ID:        "rawEvent",
+			IsVisible: &hidden,
+			Wrap:      true,
+			FontType:  "Monospace",
+			Text:      rawEventJSON(orig),
+		})
+		card.Actions = []any{teamsToggleAction{
+			Type:           "Action.ToggleVisibility",
+			Title:          tr("showRawEvent"),
+			TargetElements: []string{"rawEvent"},
+		}}
+		content = card
+	}
+
+	teams := teamsWebhook{
+		Type:          "MessageCard",
+		Context:       "https://schema.org/extensions",
+		CorrelationId: uuid.NewString(),
+		Summary:       displayMessage("teams", orig),
+		ThemeColor:    themeColor(orig),
+		Title:         tailnetTitle(c.IncludeTailnet, orig, displayLabel("teams", orig)),
+		Attachments: []attachment{
+			{
+				ContentType: "application/vnd.microsoft.card.adaptive",
+				Content:     content,
+			},
+		},
+	}
+
+	if link := displayLink("teams", orig); link != "" {
+		teams.Actions = append(teams.Actions, openURIAction(tr("viewInAdminConsole"), link))
+	}
+	if runbook := c.RunbookURL; runbook != "" {
+		teams.Actions = append(teams.Actions, openURIAction(tr("openRunbook"), runbook))
+	}
+
+	body, err := json.Marshal(teams)
+	if err != nil {
+		log.Printf("[%s] sendTeamsWebhook json.Marshal failed: %v", time.Now().Format(time.RFC3339), err)
+		return err
+	}
+
+	if _, err := deliver(outboundRequest{dest: "teams", client: client, url: webhookUrl, body: body}); err != nil {
+		log.Printf("[%s] sendTeamsWebhook deliver failed: %v", time.Now().Format(time.RFC3339), err)
+		return err
+	}
+	return nil
 }
 
+// https://adaptivecards.io/explorer/FactSet.html
+type teamsFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// createFacts renders data as Adaptive Card facts. At most max facts are
+// rendered, the last of which summarizes how many were left out.
+func createFacts(data map[string]string, max int) []teamsFact {
+	keys := fieldKeys(data)
+	omitted := 0
+	if max > 0 && len(keys) > max {
+		omitted = len(keys) - max + 1
+		keys = keys[:max-1]
+	}
+	facts := make([]teamsFact, 0, len(keys)+1)
+	for _, k := range keys {
+		facts = append(facts, teamsFact{Title: fieldLabel(k), Value: data[k]})
+	}
+	if omitted > 0 {
+		facts = append(facts, teamsFact{Title: "…", Value: fmt.Sprintf(tr("moreFields"), omitted)})
+	}
+	return facts
+}
+
+const discordAPIBaseURL = "https://discord.com/api/v10"
+
 // https://discord.com/developers/docs/resources/webhook
 type discordWebhook struct {
-	ThreadName string `json:"thread_name"`
-	Content    string `json:"content"`
+	ThreadName string             `json:"thread_name,omitempty"`
+	Content    string             `json:"content"`
+	Embeds     []discordEmbed     `json:"embeds,omitempty"`
+	Components []discordComponent `json:"components,omitempty"`
 }
 
-func sendDiscordWebhook(orig incomingWebhook) {
-	webhookUrl := os.Getenv("DISCORD_WEBHOOK_URL")
-	if webhookUrl == "" {
+// https://discord.com/developers/docs/resources/message#embed-object
+type discordEmbed struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Color       int    `json:"color"`
+}
+
+// https://discord.com/developers/docs/components/reference
+type discordComponent struct {
+	Type       int                `json:"type"`
+	Components []discordComponent `json:"components,omitempty"`
+	Style      int                `json:"style,omitempty"`
+	Label      string             `json:"label,omitempty"`
+	URL        string             `json:"url,omitempty"`
+}
+
+// Discord component types and button styles.
+const (
+	discordActionRow       = 1
+	discordButton          = 2
+	discordLinkButtonStyle = 5
+)
+
+// discordLinkButton renders a row holding a single link button.
+func discordLinkButton(label, url string) []discordComponent {
+	return []discordComponent{{
+		Type: discordActionRow,
+		Components: []discordComponent{{
+			Type:  discordButton,
+			Style: discordLinkButtonStyle,
+			Label: label,
+			URL:   url,
+		}},
+	}}
+}
+
+// sendDiscordWebhook posts the event to Discord, through the webhook in
+// DISCORD_WEBHOOK_URL or, if that is not set, as the bot identified by
+// DISCORD_BOT_TOKEN in the channel DISCORD_CHANNEL_ID. Events about a
+// device or user get a link to it in the admin console, as a button or,
+// without DISCORD_LINK_BUTTONS, as a link at the end of the content. With
+// RESOLVE_MESSAGES, messages are edited once their events are resolved.
+func sendDiscordWebhook(c DiscordConfig, client *http.Client, orig incomingWebhook) error {
+	webhookUrl := c.WebhookURL
+	botToken := c.BotToken
+	channelID := c.ChannelID
+	if webhookUrl == "" && (botToken == "" || channelID == "") {
 		// not configured
-		return
+		return nil
 	}
+	resolveDiscordMessages(c, client, orig)
 
 	discord := discordWebhook{
-		ThreadName: orig.Message,
+		Embeds: []discordEmbed{{
+			Title:       tailnetTitle(c.IncludeTailnet, orig, displayLabel("discord", orig)),
+			Description: truncateForLimit(displayMessage("discord", orig), discordDescriptionLimit),
+			Color:       themeColorInt(orig),
+		}},
 	}
 
 	buf := new(bytes.Buffer)
-	for key, val := range orig.Data {
-		fmt.Fprintf(buf, "%s=\"%s\"\n", key, val)
+	data := displayData("discord", orig)
+	keys := fieldKeys(data)
+	omitted := 0
+	if max := c.MaxFields; max > 0 && len(keys) > max {
+		omitted = len(keys) - max + 1
+		keys = keys[:max-1]
+	}
+	for _, key := range keys {
+		fmt.Fprintf(buf, "%s=\"%s\"\n", key, data[key])
+	}
+	if omitted > 0 {
+		fmt.Fprintf(buf, tr("moreFields")+"\n", omitted)
 	}
-	discord.Content = buf.String()
-	if len(discord.Content) >= 2000 {
-		r := []rune(discord.Content)
-		trunc := r[:1990]
-		discord.Content = string(trunc) + "\n...\n"
-	} else if len(discord.Content) == 0 {
-		discord.Content = orig.Message
+	limit := c.MaxLength
+	if cfg.SplitMessages {
+		// Nothing is truncated: the content is split into as many
+		// messages as it takes below.
+		limit = 0
+	}
+	link := displayLink("discord", orig)
+	var linkLine string
+	if link != "" && c.LinkButtons {
+		discord.Components = discordLinkButton(tr("viewInAdminConsole"), link)
+	} else if link != "" {
+		// The angle brackets keep Discord from embedding a preview.
+		linkLine = "[" + tr("viewInAdminConsole") + "](<" + link + ">)"
+	}
+	contentLimit := limit
+	if limit > 0 && linkLine != "" {
+		contentLimit = max(limit-utf8.RuneCountInString(linkLine)-1, 1)
+	}
+	discord.Content = truncateForLimit(buf.String(), contentLimit)
+	if linkLine != "" {
+		discord.Content = strings.TrimLeft(strings.TrimRight(discord.Content, "\n")+"\n"+linkLine, "\n")
+	}
+	var attachment []byte
+	if diff := displayDiff("discord", orig); diff != "" {
+		// Diffs that don't fit are attached in full.
+		withDiff := appendCodeBlock(discord.Content, "diff", diff, limit)
+		if withDiff != discord.Content+"\n```diff\n"+diff+"\n```" {
+			attachment = []byte(diff)
+		}
+		discord.Content = withDiff
+	}
+	if displayRawJSON("discord") {
+		discord.Content = appendCodeBlock(discord.Content, "json", rawEventJSON(orig), limit)
+	}
+	parts := []string{discord.Content}
+	if cfg.SplitMessages {
+		parts = splitMessage(discord.Content, c.MaxLength, cfg.SplitMaxParts)
+		discord.Content = parts[0]
+	}
+
+	req := outboundRequest{dest: "discord", client: client, retryAfter: discordRetryAfter}
+	if webhookUrl != "" {
+		// Messages posted to a forum channel through a webhook start a
+		// new thread. Bot messages can't be posted to forums.
+		discord.ThreadName = discordThreadName(c.ThreadNameTemplate, c.IncludeTailnet, orig)
+
+		u, err := url.Parse(webhookUrl)
+		if err != nil {
+			log.Printf("sendDiscordWebhook url.Parse failed: %v", err)
+			return err
+		}
+		query := u.Query()
+		query.Set("wait", "true")
+		if discord.Components != nil {
+			// Webhooks not owned by an application ignore components
+			// unless asked to honor them.
+			query.Set("with_components", "true")
+		}
+		u.RawQuery = query.Encode()
+		req.url = u.String()
+	} else {
+		req.url = discordAPIBaseURL + "/channels/" + url.PathEscape(channelID) + "/messages"
+		req.header = http.Header{"Authorization": {"Bot " + botToken}}
 	}
 
 	body, err := json.Marshal(discord)
 	if err != nil {
-		fmt.Printf("sendDiscordWebhook json.Marshall failed: %v\n", err)
-		return
+		log.Printf("sendDiscordWebhook json.Marshall failed: %v", err)
+		return err
+	}
+	req.body = body
+	if attachment != nil {
+		body, contentType, err := discordMultipart(body, "policy.diff", attachment)
+		if err != nil {
+			log.Printf("sendDiscordWebhook attaching policy.diff failed: %v", err)
+			return err
+		}
+		req.body = body
+		if req.header == nil {
+			req.header = http.Header{}
+		}
+		req.header.Set("Content-Type", contentType)
 	}
 
-	u, err := url.Parse(webhookUrl)
+	resp, err := deliver(req)
 	if err != nil {
-		fmt.Printf("sendDiscordWebhook url.Parse failed: %v\n", err)
-		return
+		log.Printf("sendDiscordWebhook deliver failed: %v", err)
+		return err
 	}
-	query := u.Query()
-	query.Set("wait", "true")
-	u.RawQuery = query.Encode()
-	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewBuffer(body))
-	if err != nil {
-		fmt.Printf("sendDiscordWebhook http.NewRequest failed: %v\n", err)
-		return
+	if len(parts) > 1 {
+		if err := sendDiscordParts(req, resp, parts[1:]); err != nil {
+			log.Printf("sendDiscordWebhook deliver failed: %v", err)
+			return err
+		}
 	}
 
-	req.Header.Add("Content-Type", "application/json")
+	if cfg.DryRun {
+		return nil
+	}
 
-	client := &http.Client{}
-	_, err = client.Do(req)
-	if err != nil {
-		fmt.Printf("sendDiscordWebhook client.Do failed: %v\n", err)
-		return
+	// Discord responds with the created message (for webhooks, because
+	// of wait=true).
+	var msg discordMessage
+	if err := json.Unmarshal(resp, &msg); err != nil {
+		log.Printf("sendDiscordWebhook posted message, but decoding the response failed: %v", err)
+		return nil
+	}
+	if link := msg.url(c.GuildID); link != "" {
+		log.Printf("sendDiscordWebhook posted message %s: %s", msg.ID, link)
+	} else {
+		log.Printf("sendDiscordWebhook posted message %s in channel %s", msg.ID, msg.ChannelID)
+	}
+	posted := postedDiscordMessage{url: discordMessageURL(webhookUrl, msg.ChannelID, msg.ID), msg: discord}
+	if webhookUrl != "" && msg.ChannelID == msg.ID {
+		// The message started a forum thread, which takes its ID.
+		posted.threadID = msg.ChannelID
+	}
+	discordPosted.track(cfg.Resolve, orig, posted)
+	return nil
+}
+
+// sendDiscordParts posts the remaining parts of a message split by
+// SPLIT_LONG_MESSAGES, after the first was posted with first and answered
+// with resp. The parts of a message that started a forum thread are posted
+// to that thread.
+func sendDiscordParts(first outboundRequest, resp []byte, parts []string) error {
+	var msg discordMessage
+	if json.Unmarshal(resp, &msg) == nil && msg.ID != "" && msg.ChannelID == msg.ID && first.header.Get("Authorization") == "" {
+		if u, err := url.Parse(first.url); err == nil {
+			query := u.Query()
+			query.Set("thread_id", msg.ChannelID)
+			u.RawQuery = query.Encode()
+			first.url = u.String()
+		}
+	}
+	for i, part := range parts {
+		time.Sleep(partDelays["discord"])
+		body, err := json.Marshal(discordWebhook{Content: part})
+		if err != nil {
+			return err
+		}
+		req := first
+		req.header = first.header.Clone()
+		req.body = body
+		if _, err := deliver(req); err != nil {
+			return fmt.Errorf("part %d of %d: %w", i+2, len(parts)+1, err)
+		}
+	}
+	return nil
+}
+
+// discordRetryAfter reads how long to wait from a Discord 429 response.
+// Besides the Retry-After header, Discord reports it in the body, with
+// millisecond precision.
+// https://discord.com/developers/docs/topics/rate-limits#exceeding-a-rate-limit
+func discordRetryAfter(resp *http.Response, body []byte) (time.Duration, bool) {
+	var limited struct {
+		RetryAfter float64 `json:"retry_after"`
+		Global     bool    `json:"global"`
+	}
+	if resp.StatusCode != http.StatusTooManyRequests || json.Unmarshal(body, &limited) != nil || limited.RetryAfter <= 0 {
+		return 0, false
+	}
+	if limited.Global {
+		log.Printf("discord: hit global rate limit, retrying after %.3fs", limited.RetryAfter)
 	}
+	return time.Duration(limited.RetryAfter * float64(time.Second)), true
+}
+
+// discordThreadLimit is the maximum length of a Discord thread name.
+const discordThreadLimit = 100
+
+// discordThreadName renders the DISCORD_THREAD_NAME_TEMPLATE tmpl for the
+// event, e.g. "{type}: {device}", falling back to the event message, and
+// prefixed with the tailnet if includeTailnet is set.
+func discordThreadName(tmpl string, includeTailnet bool, orig incomingWebhook) string {
+	name := orig.Message
+	if tmpl != "" {
+		if s := strings.TrimSpace(expandPlaceholders(tmpl, orig)); s != "" {
+			name = s
+		}
+	}
+	name = tailnetTitle(includeTailnet, orig, name)
+	if r := []rune(name); len(r) > discordThreadLimit {
+		name = string(r[:discordThreadLimit-1]) + "…"
+	}
+	return name
+}
+
+// https://discord.com/developers/docs/resources/message#message-object
+type discordMessage struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+	GuildID   string `json:"guild_id"`
+}
 
-	return
+// url reports a link to the message, or "" if the server it was posted in
+// is unknown. Webhook responses don't always include the guild, so it can be
+// supplied with DISCORD_GUILD_ID as defaultGuild.
+func (m discordMessage) url(defaultGuild string) string {
+	guild := m.GuildID
+	if guild == "" {
+		guild = defaultGuild
+	}
+	if guild == "" || m.ChannelID == "" || m.ID == "" {
+		return ""
+	}
+	return "https://discord.com/channels/" + guild + "/" + m.ChannelID + "/" + m.ID
 }
 
 func handleWebhook(w http.ResponseWriter, r *http.Request) {
-	secret := os.Getenv("TS_WEBHOOK_SECRET")
-	events, err := verifyWebhookSignature(r, secret)
+	secret := cfg.WebhookSecret
+	events, err := readWebhook(r, secret)
 	if err != nil {
-		fmt.Printf("[%s] handleWebhook verifyWebhookSignature: %v\n", time.Now().Format(time.RFC3339Nano), err)
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		log.Printf("handleWebhook readWebhook: %v", err)
+		webhooksRejected.WithLabelValues(rejectionReason(err)).Inc()
+		if errors.Is(err, errSchema) {
+			writeErrorDetail(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeError(w, http.StatusBadRequest)
 		return
 	}
 
-	fmt.Printf("[%s] handleWebhook received %d events\n", time.Now().Format(time.RFC3339Nano), len(events))
+	reqID := r.Header.Get("X-Request-Id")
+	if reqID == "" {
+		reqID = uuid.NewString()
+	}
+	start := time.Now()
+
+	log.Printf("handleWebhook %s received %d events", reqID, len(events))
+	stats.recordEvents(len(events))
+
+	if limit := cfg.MaxEventsPerBatch; limit > 0 && len(events) > limit {
+		if cfg.MaxEventsPolicy == "reject" {
+			log.Printf("WARNING: handleWebhook rejecting batch of %d events, more than MAX_EVENTS_PER_BATCH=%d", len(events), limit)
+			writeError(w, http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Printf("WARNING: handleWebhook received %d events, more than MAX_EVENTS_PER_BATCH=%d; dropping the last %d", len(events), limit, len(events)-limit)
+		events = events[:limit]
+	}
+	if queue != nil && cfg.Queue.FullPolicy == queueFullReject {
+		if name := queue.full(events); name != "" {
+			log.Printf("WARNING: handleWebhook %s rejecting %d events, as queue %s is full", reqID, len(events), name)
+			queueFull.WithLabelValues(name, "rejected").Add(float64(len(events)))
+			w.Header().Set("Retry-After", "30")
+			writeError(w, http.StatusServiceUnavailable)
+			return
+		}
+	}
+	latencies := map[string]time.Duration{}
+	if cfg.Forward.URL != "" {
+		hops, _ := strconv.Atoi(r.Header.Get(forwardHopsHeader))
+		fwdStart := time.Now()
+		forwardBatch(cfg.Forward, destinationClient(cfg, "forward"), events, hops)
+		latencies["forward"] = time.Since(fwdStart)
+	}
+	failed := 0
 	for _, event := range events {
-		sendTeamsWebhook(event)
-		sendDiscordWebhook(event)
+		lat, err := dispatch(event)
+		for dest, d := range lat {
+			latencies[dest] += d
+		}
+		if err != nil {
+			failed++
+		}
+	}
+	if failed > 0 && cfg.Retry.Granularity == retryGranularityBatch {
+		// Tailscale sends the batch again, and the deliveries that
+		// succeeded are skipped then.
+		log.Printf("WARNING: handleWebhook %s failed to deliver %d of %d events; asking Tailscale to send them again (RETRY_GRANULARITY=batch)", reqID, failed, len(events))
+		w.Header().Set("Retry-After", "30")
+		writeError(w, http.StatusServiceUnavailable)
+		return
+	}
+	verb := "delivered"
+	if queue != nil {
+		verb = "queued"
 	}
+	log.Printf("handleWebhook %s %s %d events in %v%s", reqID, verb, len(events), time.Since(start).Round(time.Millisecond), formatLatencies(latencies))
+	writeJSON(w, http.StatusOK, webhookAck{RequestID: reqID, Events: len(events)})
+}
+
+// webhookAck is the response to an accepted webhook.
+type webhookAck struct {
+	RequestID string `json:"requestId"`
+	Events    int    `json:"events"`
+}
+
+// formatLatencies renders per-destination delivery times for a log line.
+func formatLatencies(latencies map[string]time.Duration) string {
+	buf := new(strings.Builder)
+	for _, dest := range sortedKeys(latencies) {
+		fmt.Fprintf(buf, " %s=%v", dest, latencies[dest].Round(time.Millisecond))
+	}
+	return buf.String()
+}
+
+// dispatch forwards an event to every configured destination, reporting
+// how long each delivery took and the deliveries that failed, after
+// passing it through TRANSFORM_CMD.
+// Events that are dropped, muted or suppressed, or held back for a digest
+// or debouncing, are not delivered right away.
+func dispatch(event incomingWebhook) (map[string]time.Duration, error) {
+	received := eventHash(event)
+	event, ok := transformEvent(cfg.Transform, event)
+	if !ok {
+		return nil, nil
+	}
+	event.received = received
+	if mutes.muted(event.Type) {
+		debugf("dispatch dropped muted %s event", event.Type)
+		eventsMuted.WithLabelValues(event.Type).Inc()
+		return nil, nil
+	}
+	if !knownEventType(event.Type) {
+		// Logged regardless of the policy, to find the types that
+		// deserve formatters of their own.
+		log.Printf("dispatch received event of unknown type %q (UNKNOWN_TYPE_POLICY=%s): %s", event.Type, cfg.UnknownTypePolicy, event.Message)
+		switch cfg.UnknownTypePolicy {
+		case "drop":
+			return nil, nil
+		case "forward_tagged":
+			event.Message = "[" + tr("unknownType") + "] " + event.Message
+		}
+	}
+	sev := severityOf(event)
+	if sev < cfg.MinSeverity {
+		debugf("dispatch suppressed %s event (severity %s below %s)", event.Type, sev, cfg.MinSeverity)
+		return nil, nil
+	}
+	quiet := cfg.QuietHours.suppresses(sev, time.Now())
+	if quiet && !cfg.Digest.Enabled() {
+		debugf("dispatch suppressed %s event (severity %s) during quiet hours", event.Type, sev)
+		return nil, nil
+	}
+	event = annotateExpiry(formatEvent(enrichEvent(event)))
+	if quiet || (cfg.Digest.Enabled() && sev <= cfg.Digest.MaxSeverity) {
+		debugf("dispatch collected %s event (severity %s) for the digest", event.Type, sev)
+		digest.add(event)
+		return nil, nil
+	}
+	if debounce.add(event) {
+		return nil, nil
+	}
+	return deliverEvent(event)
+}
+
+// deliverEvent sends an event to every configured destination, reporting
+// how long each delivery took and the deliveries that failed, or queues
+// it if QUEUE_MODE is set.
+func deliverEvent(event incomingWebhook) (map[string]time.Duration, error) {
+	if queue != nil && queue.enqueue(event) {
+		return nil, nil
+	}
+	return deliverNow(event)
+}
+
+// deliverNow sends an event to every configured destination, reporting
+// how long each delivery took and the deliveries that failed.
+func deliverNow(event incomingWebhook) (map[string]time.Duration, error) {
+	latencies := make(map[string]time.Duration)
+	var errs []error
+	for _, d := range destinations {
+		if !d.configured(cfg) {
+			continue
+		}
+		start := time.Now()
+		if err := sendTo(d, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", d.name, err))
+		}
+		latencies[d.name] = time.Since(start)
+	}
+	return latencies, errors.Join(errs...)
 }
 
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	c, args, err := loadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	cfg = c
+	configureLogging(cfg)
+	port := cfg.Port
+
+	if cfg.SignatureMode != signatureModeTailscale {
+		log.Printf("WARNING: SIGNATURE_MODE=%s accepts unsigned webhooks; anyone who can reach this service can post notifications", cfg.SignatureMode)
+	}
+	registerMetrics(cfg)
+	startDigest(cfg.Digest)
+	for typ, d := range cfg.Mutes {
+		mutes.mute(typ, d)
+	}
+	httpClient.Timeout = cfg.HTTPTimeout
+
+	if err := configureOutboundTLS(); err != nil {
+		log.Fatalf("OUTBOUND_CA_FILE: %v", err)
+	}
+	restrictOutboundHosts(cfg.AllowedHosts)
+
+	if len(args) > 0 && args[0] == "replay" {
+		if err := replay(os.Stdin); err != nil {
+			log.Fatalf("replay: %v", err)
+		}
+		return
 	}
 
+	bi := getBuildInfo()
+	log.Printf("ts-webhook-adapter %s (commit %s, built %s, %s)", bi.Version, bi.Commit, bi.BuildDate, bi.GoVersion)
+
+	if dests := configuredDestinations(); len(dests) > 0 {
+		log.Printf("Forwarding events to: %s", strings.Join(dests, ", "))
+	} else if cfg.RequireDestination {
+		log.Fatalf("No destinations configured and REQUIRE_DESTINATION is set")
+	} else {
+		log.Printf("WARNING: no destinations configured; events will be dropped. See README.md for the variables to set.")
+	}
+
+	if cfg.DeliveryMode == deliveryModeAsync {
+		log.Printf("DELIVERY_MODE=async: acknowledging webhooks before delivery, with a %s queue; queued events are lost if the process crashes", cfg.Queue.Mode)
+	} else {
+		log.Printf("DELIVERY_MODE=sync: acknowledging webhooks once their events are delivered")
+		if t := cfg.Server.WriteTimeout; t > 0 && t < cfg.Retry.MaxElapsed {
+			log.Printf("WARNING: SERVER_WRITE_TIMEOUT=%v is shorter than MAX_RETRY_ELAPSED=%v; webhooks whose deliveries are retried may go unanswered", t, cfg.Retry.MaxElapsed)
+		}
+	}
+	startWarmUp(cfg.StartupDelay)
+	startQueue(cfg.Queue)
+
 	log.Printf("Listening for webhooks on port %s...\n", port)
-	http.HandleFunc("/webhook", handleWebhook)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	http.HandleFunc("/webhook", limitInflight(cfg.MaxInflightBatches, handleWebhook))
+	http.HandleFunc("/test", handleTest)
+	http.HandleFunc("/mutes", handleMutes)
+	http.HandleFunc("/ui", handleUI)
+	http.HandleFunc("/version", handleVersion)
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+	http.Handle("/metrics", metricsHandler)
+	http.HandleFunc("/stats", handleStats)
+	http.HandleFunc("/", handleIndex)
+
+	srv := &http.Server{
+		Addr:              ":" + port,
+		TLSConfig:         serverTLSConfig(cfg.TLS),
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Shutting down...")
+	// Let in-flight webhooks finish delivering before flushing
+	// destinations that batch.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown: %v", err)
+	}
+	closeAll()
 }
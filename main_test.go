@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// BenchmarkHandleWebhook measures handleWebhook delivering batches of 200
+// events to Teams, Discord, Slack and a generic webhook, all stubbed by a
+// loopback server.
+func BenchmarkHandleWebhook(b *testing.B) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{}`))
+	}))
+	defer stub.Close()
+	setTestConfig(b, map[string]string{
+		"TS_WEBHOOK_SECRET":   "secret",
+		"TEAMS_WEBHOOK_URL":   stub.URL + "/teams",
+		"DISCORD_WEBHOOK_URL": stub.URL + "/discord",
+		"SLACK_WEBHOOK_URL":   stub.URL + "/slack",
+		"GENERIC_WEBHOOK_URL": stub.URL + "/generic",
+	})
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	const batchSize = 200
+	events := make([]incomingWebhook, batchSize)
+	for i := range events {
+		events[i] = incomingWebhook{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Version:   1,
+			Type:      "nodeCreated",
+			Tailnet:   "example.com",
+			Message:   fmt.Sprintf("Node node-%d created", i),
+			Data: map[string]string{
+				"nodeID":     fmt.Sprintf("n%d", i),
+				"deviceName": fmt.Sprintf("node-%d.example.ts.net", i),
+				"managedBy":  "tag:server",
+				"actor":      "alice@example.com",
+			},
+		}
+	}
+	body, err := json.Marshal(events)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+		req.Header.Set(defaultSignatureHeader, signWebhook(body, "secret"))
+		w := httptest.NewRecorder()
+		handleWebhook(w, req)
+		if w.Code != http.StatusOK {
+			b.Fatalf("handleWebhook responded %d: %s", w.Code, w.Body)
+		}
+	}
+	b.ReportMetric(float64(b.N*batchSize)/b.Elapsed().Seconds(), "events/s")
+}
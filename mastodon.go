@@ -0,0 +1,56 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// https://docs.joinmastodon.org/methods/statuses/#create
+type mastodonStatus struct {
+	Status     string `json:"status"`
+	Visibility string `json:"visibility"`
+}
+
+// sendMastodonStatus posts the event as a status to the Mastodon (or
+// compatible) server at MASTODON_URL, as the account that MASTODON_TOKEN
+// belongs to.
+func sendMastodonStatus(c MastodonConfig, client *http.Client, orig incomingWebhook) error {
+	if c.URL == "" || c.Token == "" {
+		// not configured
+		return nil
+	}
+
+	data := displayData("mastodon", orig)
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "%s\n%s", displayLabel("mastodon", orig), displayMessage("mastodon", orig))
+	for _, k := range fieldKeys(data) {
+		fmt.Fprintf(buf, "\n%s: %s", fieldLabel(k), data[k])
+	}
+
+	err := deliverParts("mastodon", messageParts(buf.String(), c.MaxLength), func(part string) (outboundRequest, error) {
+		body, err := json.Marshal(mastodonStatus{
+			Status:     part,
+			Visibility: c.Visibility,
+		})
+		return outboundRequest{
+			dest:   "mastodon",
+			client: client,
+			url:    strings.TrimSuffix(c.URL, "/") + "/api/v1/statuses",
+			header: http.Header{"Authorization": {"Bearer " + c.Token}},
+			body:   body,
+		}, err
+	})
+	if err != nil {
+		log.Printf("sendMastodonStatus deliver failed: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry holds the metrics served on /metrics.
+var metricsRegistry = prometheus.NewRegistry()
+
+var webhooksRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ts_webhook_adapter_webhooks_rejected_total",
+	Help: "Incoming webhooks rejected before processing, by reason.",
+}, []string{"reason"})
+
+var queueFull = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ts_webhook_adapter_queue_full_total",
+	Help: "Events that arrived at a full delivery queue, by queue and the action taken.",
+}, []string{"queue", "action"})
+
+var queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ts_webhook_adapter_queue_depth",
+	Help: "Events waiting in a delivery queue, by queue.",
+}, []string{"queue"})
+
+var queueWorkers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ts_webhook_adapter_queue_workers",
+	Help: "Workers delivering the events of a queue, by queue.",
+}, []string{"queue"})
+
+var queueWorkersBusy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ts_webhook_adapter_queue_workers_busy",
+	Help: "Workers delivering an event at the moment, by queue.",
+}, []string{"queue"})
+
+var eventsMuted = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ts_webhook_adapter_events_muted_total",
+	Help: "Events dropped because their type was muted, by type.",
+}, []string{"type"})
+
+var payloadBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "ts_webhook_adapter_payload_bytes",
+	Help:    "Size of outgoing payloads in bytes, by destination.",
+	Buckets: prometheus.ExponentialBuckets(64, 4, 8), // 64B to 1MiB
+}, []string{"destination"})
+
+var deliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ts_webhook_adapter_deliveries_total",
+	Help: "Deliveries to destinations, by destination and result.",
+}, []string{"destination", "result"})
+
+var deliveryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "ts_webhook_adapter_delivery_duration_seconds",
+	Help:    "Time taken by deliveries, including retries, by destination.",
+	Buckets: prometheus.ExponentialBuckets(0.01, 4, 8), // 10ms to 2.7m
+}, []string{"destination"})
+
+// observeDeliveryMetrics records the outcome and latency of a delivery.
+func observeDeliveryMetrics(r DeliveryResult) {
+	result := "success"
+	if !r.Success {
+		result = "failure"
+	}
+	deliveries.WithLabelValues(r.Destination, result).Inc()
+	deliveryDuration.WithLabelValues(r.Destination).Observe(r.Latency.Seconds())
+}
+
+// observePayload records the size of a payload sent to dest.
+func observePayload(dest string, body []byte) {
+	payloadBytes.WithLabelValues(dest).Observe(float64(len(body)))
+	debugf("%s payload is %d bytes", dest, len(body))
+}
+
+// registerMetrics registers the metrics, labeled with INSTANCE_NAME and
+// ENVIRONMENT where set. The label for INSTANCE_NAME is instance_name, as
+// Prometheus sets instance itself.
+func registerMetrics(c *Config) {
+	labels := prometheus.Labels{}
+	if c.InstanceName != "" {
+		labels["instance_name"] = c.InstanceName
+	}
+	if c.Environment != "" {
+		labels["environment"] = c.Environment
+	}
+	prometheus.WrapRegistererWith(labels, metricsRegistry).MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		webhooksRejected,
+		queueFull,
+		queueDepth,
+		queueWorkers,
+		queueWorkersBusy,
+		eventsMuted,
+		payloadBytes,
+		deliveries,
+		deliveryDuration,
+	)
+	// Report every reason from the start, so that rates can be computed
+	// before the first rejection.
+	for _, reason := range rejectionReasons {
+		webhooksRejected.WithLabelValues(reason)
+	}
+}
+
+var metricsHandler = promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
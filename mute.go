@@ -0,0 +1,122 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// muteList holds the event types muted until a given time, e.g. during
+// planned maintenance. Events of muted types are dropped.
+type muteList struct {
+	mu    sync.Mutex
+	until map[string]time.Time // by event type
+}
+
+var mutes = &muteList{until: map[string]time.Time{}}
+
+// mute mutes events of type typ for d from now. A d that isn't positive
+// unmutes them.
+func (m *muteList) mute(typ string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if d <= 0 {
+		delete(m.until, typ)
+		return
+	}
+	m.until[typ] = time.Now().Add(d)
+}
+
+// muted reports whether events of type typ are muted.
+func (m *muteList) muted(typ string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	until, ok := m.until[typ]
+	if ok && !time.Now().Before(until) {
+		delete(m.until, typ)
+		log.Printf("mute on %s events expired", typ)
+		return false
+	}
+	return ok
+}
+
+// active reports the mutes that haven't expired.
+func (m *muteList) active() map[string]time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	maps.DeleteFunc(m.until, func(_ string, until time.Time) bool { return !now.Before(until) })
+	return maps.Clone(m.until)
+}
+
+// parseMutes parses MUTE_TYPES, a map of event type to how long after
+// startup it is muted for.
+func parseMutes(m map[string]string) (map[string]time.Duration, error) {
+	mutes := make(map[string]time.Duration, len(m))
+	var errs []error
+	for typ, v := range m {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", typ, err))
+			continue
+		}
+		mutes[typ] = d
+	}
+	return mutes, errors.Join(errs...)
+}
+
+// muteStatus is the response of /mutes, the time until which each event
+// type is muted.
+type muteStatus struct {
+	Mutes map[string]time.Time `json:"mutes"`
+}
+
+// handleMutes lists the muted event types, mutes an event type with POST
+// ?type=...&for=2h, and unmutes it with DELETE ?type=.... It is disabled
+// unless MUTE_TOKEN is set, and requires that token.
+func handleMutes(w http.ResponseWriter, r *http.Request) {
+	if cfg.MuteToken == "" {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.MuteToken)) != 1 {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+
+	typ := r.FormValue("type")
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		d, err := time.ParseDuration(r.FormValue("for"))
+		if typ == "" || err != nil || d <= 0 {
+			writeErrorDetail(w, http.StatusBadRequest, "type and a positive duration for are required, e.g. ?type=nodeDeleted&for=2h")
+			return
+		}
+		mutes.mute(typ, d)
+		log.Printf("handleMutes muted %s events for %v", typ, d)
+	case http.MethodDelete:
+		if typ == "" {
+			writeErrorDetail(w, http.StatusBadRequest, "type is required")
+			return
+		}
+		mutes.mute(typ, 0)
+		log.Printf("handleMutes unmuted %s events", typ)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, muteStatus{Mutes: mutes.active()})
+}
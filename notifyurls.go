@@ -0,0 +1,187 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// notifyScheme configures a destination in c from an Apprise-style URL
+// (https://github.com/caronc/apprise/wiki) with its scheme.
+type notifyScheme func(u *url.URL, c *Config) error
+
+// notifySchemes are the URL schemes accepted in NOTIFY_URLS. A destination
+// that can be configured by URL adds its schemes here.
+var notifySchemes = map[string]notifyScheme{
+	"msteams":   teamsNotifyURL,
+	"discord":   discordNotifyURL,
+	"slack":     slackNotifyURL,
+	"json":      genericNotifyURL("http"),
+	"jsons":     genericNotifyURL("https"),
+	"signal":    signalNotifyURL("http"),
+	"signals":   signalNotifyURL("https"),
+	"mastodon":  mastodonNotifyURL("http"),
+	"mastodons": mastodonNotifyURL("https"),
+}
+
+// applyNotifyURLs configures the destinations named by NOTIFY_URLS. As the
+// URLs hold credentials, errors only name them by scheme.
+func applyNotifyURLs(c *Config, urls []string) error {
+	var errs []error
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme == "" {
+			errs = append(errs, errors.New("invalid URL"))
+			continue
+		}
+		apply, ok := notifySchemes[u.Scheme]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unsupported scheme %s://, expected one of %s", u.Scheme, strings.Join(sortedKeys(notifySchemes), ", ")))
+			continue
+		}
+		if err := apply(u, c); err != nil {
+			errs = append(errs, fmt.Errorf("%s://: %w", u.Scheme, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// notifySegments reports the host and path segments of u, as Apprise URLs
+// often keep tokens in the host position, e.g. discord://{id}/{token}.
+func notifySegments(u *url.URL) []string {
+	var segs []string
+	for _, s := range append([]string{u.Host}, strings.Split(u.Path, "/")...) {
+		if s != "" {
+			segs = append(segs, s)
+		}
+	}
+	return segs
+}
+
+// setOnce sets *field to v, unless the destination has already been
+// configured with setting, by its own variable or an earlier URL.
+func setOnce(field *string, setting, v string) error {
+	if *field != "" {
+		return fmt.Errorf("%s is already set", setting)
+	}
+	*field = v
+	return nil
+}
+
+// teamsNotifyURL reads msteams://{team}/{tokenA}/{tokenB}/{tokenC}[/{tokenD}]
+// or, for older webhooks, msteams://{tokenA}/{tokenB}/{tokenC}.
+func teamsNotifyURL(u *url.URL, c *Config) error {
+	segs := notifySegments(u)
+	var webhook string
+	switch len(segs) {
+	case 3:
+		webhook = "https://outlook.office.com/webhook/" + segs[0] + "/IncomingWebhook/" + strings.Join(segs[1:], "/")
+	case 4, 5:
+		webhook = "https://" + segs[0] + ".webhook.office.com/webhookb2/" + segs[1] + "/IncomingWebhook/" + strings.Join(segs[2:], "/")
+	default:
+		return errors.New("expected msteams://{team}/{tokenA}/{tokenB}/{tokenC}")
+	}
+	return setOnce(&c.Teams.WebhookURL, "TEAMS_WEBHOOK_URL", webhook)
+}
+
+// discordNotifyURL reads discord://{webhookID}/{webhookToken}.
+func discordNotifyURL(u *url.URL, c *Config) error {
+	segs := notifySegments(u)
+	if len(segs) != 2 {
+		return errors.New("expected discord://{webhookID}/{webhookToken}")
+	}
+	return setOnce(&c.Discord.WebhookURL, "DISCORD_WEBHOOK_URL", "https://discord.com/api/webhooks/"+segs[0]+"/"+segs[1])
+}
+
+// slackNotifyURL reads slack://{tokenA}/{tokenB}/{tokenC} for an incoming
+// webhook, or slack://{botToken}/#{channel} for a bot.
+func slackNotifyURL(u *url.URL, c *Config) error {
+	segs := notifySegments(u)
+	if u.Fragment != "" {
+		segs = append(segs, u.Fragment)
+	}
+	switch {
+	case len(segs) == 2 && strings.HasPrefix(segs[0], "xoxb-"):
+		return errors.Join(
+			setOnce(&c.Slack.BotToken, "SLACK_BOT_TOKEN", segs[0]),
+			setOnce(&c.Slack.Channel, "SLACK_CHANNEL", strings.TrimPrefix(segs[1], "#")),
+		)
+	case len(segs) == 3:
+		return setOnce(&c.Slack.WebhookURL, "SLACK_WEBHOOK_URL", "https://hooks.slack.com/services/"+strings.Join(segs, "/"))
+	}
+	return errors.New("expected slack://{tokenA}/{tokenB}/{tokenC} or slack://{botToken}/#{channel}")
+}
+
+// genericNotifyURL reads json://[{user}:{password}@]{host}[:{port}]/{path}
+// for the generic webhook, posting to the URL with the given scheme.
+func genericNotifyURL(scheme string) notifyScheme {
+	return func(u *url.URL, c *Config) error {
+		if u.Host == "" {
+			return errors.New("expected a host")
+		}
+		target := *u
+		target.Scheme = scheme
+		target.User = nil
+		if err := setOnce(&c.Generic.URL, "GENERIC_WEBHOOK_URL", target.String()); err != nil {
+			return err
+		}
+		if u.User != nil {
+			password, _ := u.User.Password()
+			if c.Generic.Headers == nil {
+				c.Generic.Headers = http.Header{}
+			}
+			c.Generic.Headers.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password)))
+		}
+		return nil
+	}
+}
+
+// signalNotifyURL reads signal://{host}[:{port}]/{fromNumber}/{toNumber}...
+// for a signal-cli-rest-api server reached with the given scheme.
+func signalNotifyURL(scheme string) notifyScheme {
+	return func(u *url.URL, c *Config) error {
+		segs := notifySegments(u)
+		if len(segs) < 3 {
+			return errors.New("expected signal://{host}/{fromNumber}/{toNumber}")
+		}
+		if u.User != nil {
+			return errors.New("credentials are not supported")
+		}
+		if len(c.Signal.Recipients) > 0 {
+			return errors.New("SIGNAL_RECIPIENTS is already set")
+		}
+		c.Signal.Recipients = segs[2:]
+		return errors.Join(
+			setOnce(&c.Signal.APIURL, "SIGNAL_API_URL", scheme+"://"+u.Host),
+			setOnce(&c.Signal.Number, "SIGNAL_NUMBER", segs[1]),
+		)
+	}
+}
+
+// mastodonNotifyURL reads mastodon://{token}@{host}[:{port}][?visibility=...]
+// for a server reached with the given scheme.
+func mastodonNotifyURL(scheme string) notifyScheme {
+	return func(u *url.URL, c *Config) error {
+		if u.User == nil || u.User.Username() == "" || u.Host == "" {
+			return errors.New("expected mastodon://{token}@{host}")
+		}
+		if v := u.Query().Get("visibility"); v != "" {
+			if !slices.Contains([]string{"public", "unlisted", "private", "direct"}, v) {
+				return fmt.Errorf("visibility %q is not one of public, unlisted, private, direct", v)
+			}
+			c.Mastodon.Visibility = v
+		}
+		return errors.Join(
+			setOnce(&c.Mastodon.URL, "MASTODON_URL", scheme+"://"+u.Host),
+			setOnce(&c.Mastodon.Token, "MASTODON_TOKEN", u.User.Username()),
+		)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// policyFields are the data fields of policyUpdate events that hold the
+// policy file before and after the change, or a diff of the two. They are
+// shown as a diff rather than as fields.
+var policyFields = []string{"oldPolicy", "newPolicy", "diff"}
+
+// policyDiffLimit is the most a policy diff takes up in destinations
+// without a tighter limit of their own, in characters.
+const policyDiffLimit = 3000
+
+// policyDiffContext is the number of unchanged lines shown around changes.
+const policyDiffContext = 3
+
+// policyDiff renders the change to the policy file in the data of a
+// policyUpdate event as a unified diff, or reports "" if there is none or
+// the policy is redacted. A diff sent by Tailscale is used as is.
+func policyDiff(orig incomingWebhook) string {
+	if orig.Type != "policyUpdate" {
+		return ""
+	}
+	data := filterData(orig.Data)
+	switch d := data["diff"]; d {
+	case redactedValue:
+		return ""
+	case "":
+	default:
+		return d
+	}
+	oldPolicy, okOld := data["oldPolicy"]
+	newPolicy, okNew := data["newPolicy"]
+	if !okOld || !okNew || oldPolicy == redactedValue || newPolicy == redactedValue {
+		return ""
+	}
+	return unifiedDiff(oldPolicy, newPolicy, policyDiffContext)
+}
+
+// diffStat counts the lines added and removed by a unified diff.
+func diffStat(diff string) (added, removed int) {
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// formatPolicyUpdate states who changed the policy file, and by how much,
+// from the filtered data like the diff.
+func formatPolicyUpdate(orig incomingWebhook) string {
+	orig.Data = filterData(orig.Data)
+	actor := eventData(orig, "actor")
+	if actor == "" {
+		actor = tr("someone")
+	}
+	msg := fmt.Sprintf(tr("format.policyUpdated"), actor)
+	if diff := policyDiff(orig); diff != "" {
+		added, removed := diffStat(diff)
+		msg += fmt.Sprintf(" (+%d −%d)", added, removed)
+	}
+	return msg
+}
+
+// discordMultipart encodes a Discord message with payload as its JSON and
+// file attached under name, reporting the body and its Content-Type.
+// https://discord.com/developers/docs/reference#uploading-files
+func discordMultipart(payload []byte, name string, file []byte) ([]byte, string, error) {
+	buf := new(bytes.Buffer)
+	w := multipart.NewWriter(buf)
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="payload_json"`},
+		"Content-Type":        {"application/json"},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	part.Write(payload)
+	part, err = w.CreateFormFile("files[0]", name)
+	if err != nil {
+		return nil, "", err
+	}
+	part.Write(file)
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+// diffOp is a line of a diff: kept (' '), removed ('-') or added ('+').
+type diffOp struct {
+	kind byte
+	line string
+	a, b int // the line's index in the old and the new text
+}
+
+// maxDiffCells bounds the work of diffLines, which is quadratic in the
+// number of lines between the first and the last change.
+const maxDiffCells = 1 << 20
+
+// diffLines reports the operations turning lines a into lines b, by way of
+// their longest common subsequence.
+func diffLines(a, b []string) []diffOp {
+	var ops []diffOp
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		ops = append(ops, diffOp{' ', a[prefix], prefix, prefix})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	x, y := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	if len(x)*len(y) > maxDiffCells {
+		// Too big to compare line by line: show it all as replaced.
+		for i, l := range x {
+			ops = append(ops, diffOp{'-', l, prefix + i, prefix})
+		}
+		for j, l := range y {
+			ops = append(ops, diffOp{'+', l, prefix + len(x), prefix + j})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of
+		// x[i:] and y[j:].
+		lcs := make([][]int, len(x)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(y)+1)
+		}
+		for i := len(x) - 1; i >= 0; i-- {
+			for j := len(y) - 1; j >= 0; j-- {
+				if x[i] == y[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(x) || j < len(y) {
+			switch {
+			case i < len(x) && j < len(y) && x[i] == y[j]:
+				ops = append(ops, diffOp{' ', x[i], prefix + i, prefix + j})
+				i++
+				j++
+			case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+				ops = append(ops, diffOp{'-', x[i], prefix + i, prefix + j})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', y[j], prefix + i, prefix + j})
+				j++
+			}
+		}
+	}
+
+	for k := suffix; k > 0; k-- {
+		ops = append(ops, diffOp{' ', a[len(a)-k], len(a) - k, len(b) - k})
+	}
+	return ops
+}
+
+// unifiedDiff renders the changes from a to b as the hunks of a unified
+// diff, with context unchanged lines around each change.
+func unifiedDiff(a, b string, context int) string {
+	ops := diffLines(strings.Split(a, "\n"), strings.Split(b, "\n"))
+	buf := new(strings.Builder)
+	for start := 0; start < len(ops); {
+		// Find the next change, and extend the hunk around it until the
+		// changes are further apart than twice the context.
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		for k := first; k < len(ops) && k <= last+2*context; k++ {
+			if ops[k].kind != ' ' {
+				last = k
+			}
+		}
+		from, to := max(first-context, start), min(last+context+1, len(ops))
+
+		var aLines, bLines int
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aLines++
+			}
+			if op.kind != '-' {
+				bLines++
+			}
+		}
+		fmt.Fprintf(buf, "@@ -%d,%d +%d,%d @@\n", hunkStart(ops[from].a, aLines), aLines, hunkStart(ops[from].b, bLines), bLines)
+		for _, op := range ops[from:to] {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.line)
+			buf.WriteByte('\n')
+		}
+		start = to
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// hunkStart reports the line number a hunk header gives for a hunk of
+// lines from index i: that of the first line or, for an empty one, of the
+// line before it.
+func hunkStart(i, lines int) int {
+	if lines == 0 {
+		return i
+	}
+	return i + 1
+}
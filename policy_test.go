@@ -0,0 +1,65 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestPolicyDiff(t *testing.T) {
+	const (
+		oldPolicy = "{\n  \"acls\": [],\n  \"tagOwners\": {}\n}"
+		newPolicy = "{\n  \"acls\": [\"*:*\"],\n  \"tagOwners\": {}\n}"
+		computed  = "@@ -1,4 +1,4 @@\n {\n-  \"acls\": [],\n+  \"acls\": [\"*:*\"],\n   \"tagOwners\": {}\n }"
+	)
+	tests := []struct {
+		name   string
+		redact string
+		data   map[string]string
+		want   string
+	}{
+		{"computed", "", map[string]string{"oldPolicy": oldPolicy, "newPolicy": newPolicy}, computed},
+		{"sent by Tailscale", "", map[string]string{"diff": "-a\n+b", "oldPolicy": oldPolicy, "newPolicy": newPolicy}, "-a\n+b"},
+		{"empty diff", "", map[string]string{"diff": "", "oldPolicy": oldPolicy, "newPolicy": newPolicy}, computed},
+		{"diff redacted", "diff", map[string]string{"diff": "-a\n+b"}, ""},
+		{"diff redacted with policies", "diff", map[string]string{"diff": "-a\n+b", "oldPolicy": oldPolicy, "newPolicy": newPolicy}, ""},
+		{"policy redacted", "newPolicy", map[string]string{"oldPolicy": oldPolicy, "newPolicy": newPolicy}, ""},
+		{"policy missing", "", map[string]string{"oldPolicy": oldPolicy}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, map[string]string{"REDACT_FIELDS": tt.redact})
+			got := policyDiff(incomingWebhook{Type: "policyUpdate", Data: tt.data})
+			if got != tt.want {
+				t.Errorf("policyDiff = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffStat(t *testing.T) {
+	added, removed := diffStat("@@ -1,2 +1,3 @@\n a\n-b\n+c\n+d")
+	if added != 2 || removed != 1 {
+		t.Errorf("diffStat = +%d -%d, want +2 -1", added, removed)
+	}
+}
+
+func TestFormatPolicyUpdateActor(t *testing.T) {
+	data := map[string]string{"actor": "alice@example.com", "diff": "-a\n+b"}
+	tests := []struct {
+		env  map[string]string
+		want string
+	}{
+		{nil, "alice@example.com updated the tailnet policy file (+1 −1)"},
+		{map[string]string{"REDACT_FIELDS": "actor"}, "[redacted] updated the tailnet policy file (+1 −1)"},
+		{map[string]string{"DROP_FIELDS": "actor"}, "Someone updated the tailnet policy file (+1 −1)"},
+	}
+	for _, tt := range tests {
+		setTestConfig(t, tt.env)
+		if got := formatPolicyUpdate(incomingWebhook{Type: "policyUpdate", Data: data}); got != tt.want {
+			t.Errorf("%v: formatPolicyUpdate = %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Formatting profiles, set with FORMAT_PROFILE and <DEST>_FORMAT_PROFILE,
+// for how much of an event a destination's messages show.
+const (
+	// profileFull shows the event with its data fields formatted as set
+	// by FORMAT_VALUES, policy diffs and admin console links.
+	profileFull = "full"
+	// profileTerse shows only the event's label and message, without
+	// emoji, for destinations that are read on a phone or charged by the
+	// character.
+	profileTerse = "terse"
+	// profileRaw shows the event with its data fields as received, and
+	// nothing the adapter adds: no formatted values, diffs or links.
+	profileRaw = "raw"
+)
+
+var formatProfiles = []string{profileFull, profileTerse, profileRaw}
+
+// profileDestinations are the destinations that accept
+// <DEST>_FORMAT_PROFILE: those that render events as messages.
+var profileDestinations = []string{"teams", "discord", "slack", "signal", "line", "mastodon"}
+
+// formatProfile reports the formatting profile of dest.
+func formatProfile(dest string) string {
+	if p, ok := cfg.Profiles[dest]; ok {
+		return p
+	}
+	return profileFull
+}
+
+// displayMessage reports the event's message as dest shows it.
+func displayMessage(dest string, orig incomingWebhook) string {
+	if formatProfile(dest) == profileTerse {
+		return stripEmoji(orig.Message)
+	}
+	return orig.Message
+}
+
+// displayLabel reports the label of the event's type as dest shows it.
+func displayLabel(dest string, orig incomingWebhook) string {
+	if formatProfile(dest) == profileTerse {
+		return stripEmoji(eventLabel(orig.Type))
+	}
+	return eventLabel(orig.Type)
+}
+
+// displayLink reports the admin console link dest shows for the event, or
+// "" if it shows none.
+func displayLink(dest string, orig incomingWebhook) string {
+	if formatProfile(dest) != profileFull {
+		return ""
+	}
+	return adminConsoleURL(orig)
+}
+
+// displayDiff reports the policy diff dest shows for the event, or "" if
+// it shows none.
+func displayDiff(dest string, orig incomingWebhook) string {
+	if formatProfile(dest) != profileFull {
+		return ""
+	}
+	return policyDiff(orig)
+}
+
+// displayRawJSON reports whether dest shows the raw event, as set by
+// INCLUDE_RAW_JSON.
+func displayRawJSON(dest string) bool {
+	return cfg.IncludeRawJSON && formatProfile(dest) != profileTerse
+}
+
+// stripEmoji removes emoji, and the joiners and variation selectors that
+// combine them, from s. Messages to SMS gateways that contain any are sent
+// in a wider encoding, which fits less than half as much in a message.
+func stripEmoji(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\u200d', r >= '\ufe00' && r <= '\ufe0f':
+			// Joiners and variation selectors.
+			return -1
+		case r >= 0x1f000 && r <= 0x1faff, r >= 0x2600 && r <= 0x27bf, r >= 0x2b00 && r <= 0x2bff:
+			// Pictographs, including skin tones and flags, dingbats and
+			// symbols.
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(doubleSpaceRE.ReplaceAllString(s, " "))
+}
+
+var doubleSpaceRE = regexp.MustCompile(` {2,}`)
@@ -0,0 +1,107 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub/v2"
+)
+
+// pubsubEndpoint is where the Pub/Sub client publishes, unless
+// PUBSUB_EMULATOR_HOST is set.
+const pubsubEndpoint = "https://pubsub.googleapis.com"
+
+var (
+	pubsubOnce      sync.Once
+	pubsubClient    *pubsub.Client
+	pubsubPublisher *pubsub.Publisher
+	pubsubErr       error
+	pubsubPending   sync.WaitGroup
+)
+
+// getPubSubPublisher creates the Pub/Sub publisher on first use, with
+// application default credentials.
+func getPubSubPublisher(project, topic string) (*pubsub.Publisher, error) {
+	pubsubOnce.Do(func() {
+		pubsubClient, pubsubErr = pubsub.NewClient(context.Background(), project)
+		if pubsubErr != nil {
+			return
+		}
+		// The publisher batches messages, sending them when a batch
+		// fills or after a short delay (see pubsub.DefaultPublishSettings).
+		pubsubPublisher = pubsubClient.Publisher(topic)
+	})
+	return pubsubPublisher, pubsubErr
+}
+
+// sendPubSubMessage publishes the event as JSON to PUBSUB_TOPIC in
+// PUBSUB_PROJECT, with the event type and tailnet as attributes so that
+// subscriptions can filter on them.
+func sendPubSubMessage(c PubSubConfig, orig incomingWebhook) error {
+	project := c.Project
+	topic := c.Topic
+	if project == "" || topic == "" {
+		// not configured
+		return nil
+	}
+
+	orig.Data = filterData(orig.Data)
+	body, err := json.Marshal(orig)
+	if err != nil {
+		log.Printf("sendPubSubMessage json.Marshal failed: %v", err)
+		return err
+	}
+	observePayload("pubsub", body)
+
+	if cfg.DryRun {
+		log.Printf("sendPubSubMessage (dry run): %s", body)
+		return nil
+	}
+
+	start := time.Now()
+	publisher, err := getPubSubPublisher(project, topic)
+	if err != nil {
+		recordDelivery("pubsub", start, err)
+		log.Printf("sendPubSubMessage pubsub.NewClient failed: %v", err)
+		return err
+	}
+
+	result := publisher.Publish(context.Background(), &pubsub.Message{
+		Data: body,
+		Attributes: map[string]string{
+			"type":    orig.Type,
+			"tailnet": orig.Tailnet,
+		},
+	})
+	// Wait for the batch to be sent in the background, rather than
+	// holding up the other destinations.
+	pubsubPending.Add(1)
+	go func() {
+		defer pubsubPending.Done()
+		id, err := result.Get(context.Background())
+		recordDelivery("pubsub", start, err)
+		if err != nil {
+			log.Printf("sendPubSubMessage Publish failed: %v", err)
+			return
+		}
+		debugf("sendPubSubMessage published message %s", id)
+	}()
+	return nil
+}
+
+// closePubSub sends any batched messages and closes the client.
+func closePubSub() error {
+	if pubsubPublisher == nil {
+		return nil
+	}
+	pubsubPublisher.Stop()
+	pubsubPending.Wait()
+	return pubsubClient.Close()
+}
@@ -0,0 +1,241 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// DELIVERY_MODE values.
+const (
+	// deliveryModeSync delivers events in the handler of the webhook that
+	// carried them, acknowledging the webhook once they are delivered.
+	deliveryModeSync = "sync"
+	// deliveryModeAsync acknowledges webhooks at once, and queues their
+	// events for delivery in the background as set by QUEUE_MODE.
+	deliveryModeAsync = "async"
+)
+
+// QUEUE_MODE values, for DELIVERY_MODE=async.
+const (
+	// queueModeShared queues events for a pool of QUEUE_WORKERS workers,
+	// each of which delivers an event to every destination in turn.
+	queueModeShared = "shared"
+	// queueModePerDestination gives every destination a queue and a
+	// worker of its own, so that a slow or blocked destination only
+	// backs up its own queue.
+	queueModePerDestination = "per-destination"
+	// queueModePerTailnet gives every tailnet a queue and a worker of its
+	// own, started with its first event, so that each tailnet's events are
+	// delivered in the order they arrived while tailnets are delivered in
+	// parallel.
+	queueModePerTailnet = "per-tailnet"
+)
+
+// QUEUE_FULL_POLICY values, for when an event arrives at a full queue.
+const (
+	// queueFullBlock waits for room in the queue.
+	queueFullBlock = "block"
+	// queueFullDropOldest drops the longest-queued event to make room.
+	queueFullDropOldest = "drop_oldest"
+	// queueFullDropNew drops the arriving event.
+	queueFullDropNew = "drop_new"
+	// queueFullReject responds to webhooks that would overflow the queue
+	// with a 503 status, so that Tailscale sends them again later. Events
+	// queued other than by a webhook, such as digests, wait for room.
+	queueFullReject = "reject"
+)
+
+// deliveryQueue holds events for delivery in the background.
+type deliveryQueue struct {
+	mu     sync.RWMutex
+	closed bool
+	policy string
+	size   int
+	shared chan incomingWebhook
+	byDest map[string]chan incomingWebhook
+	wg     sync.WaitGroup
+
+	tailnetMu sync.Mutex
+	byTailnet map[string]chan incomingWebhook // for queueModePerTailnet
+}
+
+// queue is the delivery queue, or nil if events are delivered directly.
+var queue *deliveryQueue
+
+// startQueue starts the workers for QUEUE_MODE, if set.
+func startQueue(c QueueConfig) {
+	if c.Mode == "" {
+		return
+	}
+	q := &deliveryQueue{policy: c.FullPolicy, size: c.Size}
+	switch c.Mode {
+	case queueModeShared:
+		q.shared = make(chan incomingWebhook, c.Size)
+		for range c.Workers {
+			q.work(queueModeShared, q.shared, func(event incomingWebhook) { deliverNow(event) })
+		}
+	case queueModePerDestination:
+		q.byDest = map[string]chan incomingWebhook{}
+		for _, d := range destinations {
+			ch := make(chan incomingWebhook, c.Size)
+			q.byDest[d.name] = ch
+			q.work(d.name, ch, func(event incomingWebhook) { sendTo(d, event) })
+		}
+	case queueModePerTailnet:
+		q.byTailnet = map[string]chan incomingWebhook{}
+	}
+	queue = q
+}
+
+// enqueue queues the event for delivery, reporting false if the queue
+// has been closed.
+func (q *deliveryQueue) enqueue(event incomingWebhook) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+	if q.shared != nil {
+		q.put(queueModeShared, q.shared, event)
+		return true
+	}
+	if q.byTailnet != nil {
+		q.put(tailnetQueueName(event.Tailnet), q.tailnetQueue(event.Tailnet), event)
+		return true
+	}
+	for _, d := range destinations {
+		if d.configured(cfg) {
+			q.put(d.name, q.byDest[d.name], event)
+		}
+	}
+	return true
+}
+
+// work starts a worker delivering the events of the queue ch, named name,
+// with deliver, and keeps its queue metrics up to date.
+func (q *deliveryQueue) work(name string, ch chan incomingWebhook, deliver func(incomingWebhook)) {
+	depth := queueDepth.WithLabelValues(name)
+	busy := queueWorkersBusy.WithLabelValues(name)
+	queueWorkers.WithLabelValues(name).Inc()
+	q.wg.Go(func() {
+		defer queueWorkers.WithLabelValues(name).Dec()
+		for event := range ch {
+			depth.Set(float64(len(ch)))
+			busy.Inc()
+			deliver(event)
+			busy.Dec()
+		}
+	})
+}
+
+// tailnetQueueName names the queue of a tailnet in logs and metrics.
+func tailnetQueueName(tailnet string) string {
+	return "tailnet:" + tailnet
+}
+
+// tailnetQueue reports the queue of a tailnet, starting its worker if it
+// has none yet. The caller must hold q.mu for reading.
+func (q *deliveryQueue) tailnetQueue(tailnet string) chan incomingWebhook {
+	q.tailnetMu.Lock()
+	defer q.tailnetMu.Unlock()
+	ch := q.byTailnet[tailnet]
+	if ch == nil {
+		ch = make(chan incomingWebhook, q.size)
+		q.byTailnet[tailnet] = ch
+		q.work(tailnetQueueName(tailnet), ch, func(event incomingWebhook) { deliverNow(event) })
+	}
+	return ch
+}
+
+// put adds the event to the queue ch, named name, following
+// QUEUE_FULL_POLICY if it is full.
+func (q *deliveryQueue) put(name string, ch chan incomingWebhook, event incomingWebhook) {
+	defer func() { queueDepth.WithLabelValues(name).Set(float64(len(ch))) }()
+	select {
+	case ch <- event:
+		return
+	default:
+	}
+	switch q.policy {
+	case queueFullDropNew:
+		queueFull.WithLabelValues(name, "dropped_new").Inc()
+		log.Printf("WARNING: queue %s is full; dropping %s event %q", name, event.Type, event.Message)
+	case queueFullDropOldest:
+		for {
+			select {
+			case ch <- event:
+				return
+			case old := <-ch:
+				queueFull.WithLabelValues(name, "dropped_oldest").Inc()
+				log.Printf("WARNING: queue %s is full; dropping %s event %q", name, old.Type, old.Message)
+			}
+		}
+	default:
+		queueFull.WithLabelValues(name, "blocked").Inc()
+		ch <- event
+	}
+}
+
+// full reports the name of a queue without room for the events, or "" if
+// there is room in every queue they would be added to. A batch larger than
+// QUEUE_SIZE only needs the queue to be empty, so that it is not rejected
+// forever.
+func (q *deliveryQueue) full(events []incomingWebhook) string {
+	n := len(events)
+	hasRoom := func(ch chan incomingWebhook) bool {
+		return cap(ch)-len(ch) >= min(n, cap(ch))
+	}
+	if q.byTailnet != nil {
+		perTailnet := map[string]int{}
+		for _, e := range events {
+			perTailnet[e.Tailnet]++
+		}
+		q.tailnetMu.Lock()
+		defer q.tailnetMu.Unlock()
+		for tailnet, n := range perTailnet {
+			if ch := q.byTailnet[tailnet]; ch != nil && cap(ch)-len(ch) < min(n, cap(ch)) {
+				return tailnetQueueName(tailnet)
+			}
+		}
+		return ""
+	}
+	if q.shared != nil {
+		if !hasRoom(q.shared) {
+			return queueModeShared
+		}
+		return ""
+	}
+	for _, d := range destinations {
+		if d.configured(cfg) && !hasRoom(q.byDest[d.name]) {
+			return d.name
+		}
+	}
+	return ""
+}
+
+// Close stops accepting events and waits for the queued ones to be
+// delivered.
+func (q *deliveryQueue) Close() error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	q.closed = true
+	if q.shared != nil {
+		close(q.shared)
+	}
+	for _, ch := range q.byDest {
+		close(ch)
+	}
+	for _, ch := range q.byTailnet {
+		close(ch)
+	}
+	q.mu.Unlock()
+	log.Printf("Waiting for queued deliveries...")
+	q.wg.Wait()
+	return nil
+}
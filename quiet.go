@@ -0,0 +1,82 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// quietWindow is a daily time window, such as 22:00-07:00, during which
+// only events of at least a minimum severity are forwarded.
+type quietWindow struct {
+	start, end  time.Duration // since midnight
+	loc         *time.Location
+	minSeverity severity
+}
+
+// parseQuietHours parses a window such as "22:00-07:00" in the named
+// timezone. Windows whose end is before their start cross midnight.
+func parseQuietHours(window, tz, minSev string) (*quietWindow, error) {
+	if window == "" {
+		return nil, nil
+	}
+	startStr, endStr, ok := strings.Cut(window, "-")
+	if !ok {
+		return nil, fmt.Errorf("%q is not of the form HH:MM-HH:MM", window)
+	}
+	start, err := parseClock(startStr)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(endStr)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("%q is empty", window)
+	}
+	loc := time.Local
+	if tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return nil, err
+		}
+	}
+	q := &quietWindow{start: start, end: end, loc: loc, minSeverity: severityCritical}
+	if minSev != "" {
+		if q.minSeverity, err = parseSeverity(minSev); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// parseClock parses a time of day such as "07:30".
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, want HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains reports whether t falls within the window.
+func (q *quietWindow) contains(t time.Time) bool {
+	t = t.In(q.loc)
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if q.start < q.end {
+		// e.g. 12:00-14:00
+		return now >= q.start && now < q.end
+	}
+	// Crosses midnight, e.g. 22:00-07:00.
+	return now >= q.start || now < q.end
+}
+
+// suppresses reports whether an event of the given severity should be
+// held back at time t.
+func (q *quietWindow) suppresses(sev severity, t time.Time) bool {
+	return q != nil && sev < q.minSeverity && q.contains(t)
+}
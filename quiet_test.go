@@ -0,0 +1,75 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietWindowContains(t *testing.T) {
+	tests := []struct {
+		window string
+		tz     string
+		at     string // RFC 3339
+		want   bool
+	}{
+		// Crossing midnight.
+		{"22:00-07:00", "UTC", "2024-01-02T21:59:59Z", false},
+		{"22:00-07:00", "UTC", "2024-01-02T22:00:00Z", true},
+		{"22:00-07:00", "UTC", "2024-01-02T23:59:59Z", true},
+		{"22:00-07:00", "UTC", "2024-01-03T00:00:00Z", true},
+		{"22:00-07:00", "UTC", "2024-01-03T06:59:59Z", true},
+		{"22:00-07:00", "UTC", "2024-01-03T07:00:00Z", false},
+		{"22:00-07:00", "UTC", "2024-01-03T12:00:00Z", false},
+
+		// Within a day.
+		{"12:00-14:00", "UTC", "2024-01-02T11:59:59Z", false},
+		{"12:00-14:00", "UTC", "2024-01-02T12:00:00Z", true},
+		{"12:00-14:00", "UTC", "2024-01-02T13:59:59Z", true},
+		{"12:00-14:00", "UTC", "2024-01-02T14:00:00Z", false},
+		{"12:00-14:00", "UTC", "2024-01-02T00:00:00Z", false},
+
+		// In New York, 22:00 EST is 03:00 UTC the next day, and 07:00 EST
+		// is 12:00 UTC.
+		{"22:00-07:00", "America/New_York", "2024-01-03T02:59:59Z", false},
+		{"22:00-07:00", "America/New_York", "2024-01-03T03:00:00Z", true},
+		{"22:00-07:00", "America/New_York", "2024-01-03T05:00:00Z", true}, // midnight there
+		{"22:00-07:00", "America/New_York", "2024-01-03T11:59:59Z", true},
+		{"22:00-07:00", "America/New_York", "2024-01-03T12:00:00Z", false},
+		// 12:00 EDT is 16:00 UTC in summer.
+		{"12:00-14:00", "America/New_York", "2024-07-02T15:59:59Z", false},
+		{"12:00-14:00", "America/New_York", "2024-07-02T16:00:00Z", true},
+		{"12:00-14:00", "America/New_York", "2024-07-02T17:59:59Z", true},
+		{"12:00-14:00", "America/New_York", "2024-07-02T18:00:00Z", false},
+		// Times given in another zone are converted.
+		{"12:00-14:00", "Asia/Tokyo", "2024-01-02T12:30:00+09:00", true},
+		{"12:00-14:00", "Asia/Tokyo", "2024-01-02T12:30:00Z", false},
+	}
+	for _, tt := range tests {
+		q, err := parseQuietHours(tt.window, tt.tz, "")
+		if err != nil {
+			t.Fatalf("parseQuietHours(%q, %q): %v", tt.window, tt.tz, err)
+		}
+		at, err := time.Parse(time.RFC3339, tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := q.contains(at); got != tt.want {
+			t.Errorf("%s in %s contains %s = %v, want %v", tt.window, tt.tz, tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestParseQuietHoursErrors(t *testing.T) {
+	for _, window := range []string{"22:00", "22:00-25:00", "7-8", "12:00-12:00"} {
+		if _, err := parseQuietHours(window, "UTC", ""); err == nil {
+			t.Errorf("parseQuietHours(%q) succeeded, want an error", window)
+		}
+	}
+	if _, err := parseQuietHours("22:00-07:00", "Mars/Olympus_Mons", ""); err == nil {
+		t.Error("parseQuietHours with an unknown timezone succeeded, want an error")
+	}
+}
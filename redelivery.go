@@ -0,0 +1,68 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// RETRY_GRANULARITY values, for what is retried when some of the events
+// of a batch can't be delivered.
+const (
+	// retryGranularityEvent retries each failed delivery on its own, and
+	// acknowledges the webhook regardless, so that the events that were
+	// delivered are not sent again.
+	retryGranularityEvent = "event"
+	// retryGranularityBatch responds to the webhook with a 503 status, so
+	// that Tailscale sends the whole batch again. Deliveries that
+	// succeeded are remembered for redeliveryWindow and skipped then.
+	retryGranularityBatch = "batch"
+)
+
+// redeliveryWindow is how long successful deliveries are remembered with
+// RETRY_GRANULARITY=batch, covering the time Tailscale takes to give up
+// on a webhook.
+const redeliveryWindow = time.Hour
+
+// deliveredEvents remembers which events were delivered to which
+// destinations, by the eventHash of the event as received: what dispatch
+// adds to it, such as how soon a key expires, changes between deliveries.
+type deliveredEvents struct {
+	mu sync.Mutex
+	at map[string]time.Time // by destination and event hash
+}
+
+var redeliveries = &deliveredEvents{at: map[string]time.Time{}}
+
+func deliveredKey(dest string, event incomingWebhook) string {
+	hash := event.received
+	if hash == "" {
+		hash = eventHash(event)
+	}
+	return dest + "\x00" + hash
+}
+
+// add remembers that the event was delivered to dest.
+func (d *deliveredEvents) add(dest string, event incomingWebhook) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for k, t := range d.at {
+		if now.Sub(t) > redeliveryWindow {
+			delete(d.at, k)
+		}
+	}
+	d.at[deliveredKey(dest, event)] = now
+}
+
+// delivered reports whether the event was delivered to dest within the
+// redeliveryWindow.
+func (d *deliveredEvents) delivered(dest string, event incomingWebhook) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t, ok := d.at[deliveredKey(dest, event)]
+	return ok && time.Since(t) <= redeliveryWindow
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRedeliverySkipsAnnotatedEvents checks that an event delivered
+// before its batch was sent again is skipped, although the expiry text
+// annotateExpiry adds to it has changed in the meantime.
+func TestRedeliverySkipsAnnotatedEvents(t *testing.T) {
+	var posts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+	}))
+	defer srv.Close()
+	setTestConfig(t, map[string]string{
+		"GENERIC_WEBHOOK_URL": srv.URL,
+		"DELIVERY_MODE":       "sync",
+		"RETRY_GRANULARITY":   "batch",
+	})
+	old := redeliveries
+	redeliveries = &deliveredEvents{at: map[string]time.Time{}}
+	t.Cleanup(func() { redeliveries = old })
+
+	event := incomingWebhook{
+		Type:    "nodeKeyExpired",
+		Message: "Node key expired",
+		Data:    map[string]string{"expiry": time.Now().Add(-10 * time.Second).UTC().Format(time.RFC3339)},
+	}
+	first := annotateExpiry(event).Message
+	if _, err := dispatch(event); err != nil {
+		t.Fatalf("first dispatch: %v", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if again := annotateExpiry(event).Message; again == first {
+		t.Fatalf("annotated message %q did not change", again)
+	}
+	if _, err := dispatch(event); err != nil {
+		t.Fatalf("second dispatch: %v", err)
+	}
+	if n := posts.Load(); n != 1 {
+		t.Errorf("generic webhook received %d posts, want 1", n)
+	}
+}
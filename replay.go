@@ -0,0 +1,44 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+)
+
+// replay reads a captured event, or a JSON array of events as POSTed by
+// Tailscale, and dispatches them to the configured destinations without
+// starting the HTTP server or verifying a signature.
+func replay(r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	b = bytes.TrimSpace(b)
+
+	var events []incomingWebhook
+	if len(b) > 0 && b[0] == '[' {
+		err = json.Unmarshal(b, &events)
+	} else {
+		var event incomingWebhook
+		err = json.Unmarshal(b, &event)
+		events = append(events, event)
+	}
+	if err != nil {
+		return fmt.Errorf("decoding events: %w", err)
+	}
+
+	log.Printf("replay dispatching %d events", len(events))
+	for _, event := range events {
+		dispatch(event)
+	}
+	// Don't leave events held back or buffered behind.
+	closeAll()
+	return nil
+}
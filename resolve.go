@@ -0,0 +1,252 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultResolveTypes are the default RESOLVE_TYPES: the event types that
+// resolve earlier events of other types about the same device or user.
+var defaultResolveTypes = map[string][]string{
+	"nodeApproved":   {"nodeNeedsApproval"},
+	"userApproved":   {"userNeedsApproval"},
+	"nodeKeyExpired": {"nodeKeyExpiringInOneDay"},
+	"nodeDeleted":    {"nodeNeedsApproval", "nodeKeyExpiringInOneDay"},
+}
+
+// parseResolveTypes parses RESOLVE_TYPES, a map of each resolving event
+// type to the types it resolves, separated by "|".
+func parseResolveTypes(m map[string]string) map[string][]string {
+	if len(m) == 0 {
+		return defaultResolveTypes
+	}
+	types := make(map[string][]string, len(m))
+	for resolver, resolved := range m {
+		for _, t := range strings.Split(resolved, "|") {
+			if t = strings.TrimSpace(t); t != "" {
+				types[resolver] = append(types[resolver], t)
+			}
+		}
+	}
+	return types
+}
+
+// resolvedBy reports whether events of type t may be resolved later.
+func (c ResolveConfig) resolvedBy(t string) bool {
+	for _, resolved := range c.Types {
+		if slices.Contains(resolved, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolutionKey identifies the event of type t about the same device, or
+// else user, in the same tailnet as orig. It is "" for events about
+// neither, which can't be paired.
+func resolutionKey(orig incomingWebhook, t string) string {
+	subject := eventDevice(orig)
+	if subject == "" {
+		subject = eventUser(orig)
+	}
+	if subject == "" {
+		return ""
+	}
+	return orig.Tailnet + "\x00" + t + "\x00" + subject
+}
+
+// trackedMessage is a posted message that can be edited when its event is
+// resolved.
+type trackedMessage[T any] struct {
+	msg    T
+	posted time.Time
+}
+
+// messageTracker remembers the messages posted for events that may be
+// resolved later, by resolutionKey, for a destination that can edit its
+// messages.
+type messageTracker[T any] struct {
+	mu       sync.Mutex
+	messages map[string]trackedMessage[T]
+}
+
+func newMessageTracker[T any]() *messageTracker[T] {
+	return &messageTracker[T]{messages: map[string]trackedMessage[T]{}}
+}
+
+// track remembers msg as the message posted for orig, if RESOLVE_MESSAGES
+// is set and orig is of a type that may be resolved.
+func (t *messageTracker[T]) track(c ResolveConfig, orig incomingWebhook, msg T) {
+	if !c.Enabled || !c.resolvedBy(orig.Type) {
+		return
+	}
+	key := resolutionKey(orig, orig.Type)
+	if key == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, m := range t.messages {
+		if time.Since(m.posted) > c.Window {
+			delete(t.messages, k)
+		}
+	}
+	t.messages[key] = trackedMessage[T]{msg: msg, posted: time.Now()}
+}
+
+// resolve forgets and reports the messages resolved by orig, posted within
+// RESOLVE_WINDOW.
+func (t *messageTracker[T]) resolve(c ResolveConfig, orig incomingWebhook) []T {
+	if !c.Enabled {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var resolved []T
+	for _, typ := range c.Types[orig.Type] {
+		key := resolutionKey(orig, typ)
+		if m, ok := t.messages[key]; ok && key != "" {
+			delete(t.messages, key)
+			if time.Since(m.posted) <= c.Window {
+				resolved = append(resolved, m.msg)
+			}
+		}
+	}
+	return resolved
+}
+
+// resolvedColor highlights resolved messages.
+const resolvedColor = 0x2EB886 // green
+
+// resolvedTitle marks the title of a resolved message.
+func resolvedTitle(title string) string {
+	return "✅ [" + tr("resolved") + "] " + title
+}
+
+// resolutionText describes the event that resolved a message.
+func resolutionText(resolver incomingWebhook) string {
+	return fmt.Sprintf(tr("resolvedBy"), eventLabel(resolver.Type)+": "+resolver.Message)
+}
+
+// postedSlackMessage is a bot message that can be updated with
+// chat.update.
+type postedSlackMessage struct {
+	channel string // ID, as reported by chat.postMessage
+	ts      string
+	msg     slackMessage
+}
+
+var slackPosted = newMessageTracker[postedSlackMessage]()
+
+// resolveSlackMessages marks the Slack messages resolved by orig as such.
+// https://api.slack.com/methods/chat.update
+func resolveSlackMessages(c SlackConfig, client *http.Client, orig incomingWebhook) {
+	for _, p := range slackPosted.resolve(cfg.Resolve, orig) {
+		update := struct {
+			slackMessage
+			TS string `json:"ts"`
+		}{p.msg, p.ts}
+		update.Channel = p.channel
+		update.ThreadTS = ""
+		update.Text = resolvedTitle(update.Text)
+		if len(update.Attachments) > 0 {
+			a := &update.Attachments[0]
+			a.Color = fmt.Sprintf("#%06X", resolvedColor)
+			a.Title = resolvedTitle(a.Title)
+			a.Fields = append(a.Fields, slackField{Title: tr("resolved"), Value: resolutionText(orig)})
+		}
+		body, err := json.Marshal(update)
+		if err != nil {
+			log.Printf("resolveSlackMessages json.Marshal failed: %v", err)
+			continue
+		}
+		_, err = deliver(outboundRequest{
+			dest:   "slack",
+			client: client,
+			url:    slackAPIBaseURL + "/chat.update",
+			header: http.Header{"Authorization": {"Bearer " + c.BotToken}},
+			body:   body,
+			check:  checkSlackResponse("chat.update"),
+		})
+		if err != nil {
+			log.Printf("resolveSlackMessages deliver failed: %v", err)
+		}
+	}
+}
+
+// postedDiscordMessage is a message that can be edited, through the
+// webhook that posted it or as the bot.
+type postedDiscordMessage struct {
+	url      string // of the message, for PATCH
+	threadID string // of the forum thread the webhook started, if any
+	msg      discordWebhook
+}
+
+var discordPosted = newMessageTracker[postedDiscordMessage]()
+
+// discordMessageURL reports the URL to edit a message posted through the
+// webhook in webhookURL or, if that is "", in channelID as the bot.
+// https://discord.com/developers/docs/resources/webhook#edit-webhook-message
+// https://discord.com/developers/docs/resources/message#edit-message
+func discordMessageURL(webhookURL, channelID, messageID string) string {
+	if webhookURL == "" {
+		return discordAPIBaseURL + "/channels/" + url.PathEscape(channelID) + "/messages/" + url.PathEscape(messageID)
+	}
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return ""
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/messages/" + url.PathEscape(messageID)
+	u.RawQuery = ""
+	return u.String()
+}
+
+// resolveDiscordMessages marks the Discord messages resolved by orig as
+// such.
+func resolveDiscordMessages(c DiscordConfig, client *http.Client, orig incomingWebhook) {
+	for _, p := range discordPosted.resolve(cfg.Resolve, orig) {
+		edit := p.msg
+		edit.ThreadName = "" // can't be edited
+		edit.Embeds = append([]discordEmbed(nil), edit.Embeds...)
+		if len(edit.Embeds) > 0 {
+			e := &edit.Embeds[0]
+			e.Title = resolvedTitle(e.Title)
+			e.Color = resolvedColor
+			e.Description = strings.TrimSpace(e.Description + "\n\n" + resolutionText(orig))
+		}
+		body, err := json.Marshal(edit)
+		if err != nil {
+			log.Printf("resolveDiscordMessages json.Marshal failed: %v", err)
+			continue
+		}
+		req := outboundRequest{dest: "discord", client: client, method: http.MethodPatch, url: p.url, body: body, retryAfter: discordRetryAfter}
+		if c.WebhookURL == "" {
+			req.header = http.Header{"Authorization": {"Bot " + c.BotToken}}
+		} else {
+			query := url.Values{}
+			if p.threadID != "" {
+				query.Set("thread_id", p.threadID)
+			}
+			if edit.Components != nil {
+				query.Set("with_components", "true")
+			}
+			if len(query) > 0 {
+				req.url += "?" + query.Encode()
+			}
+		}
+		if _, err := deliver(req); err != nil {
+			log.Printf("resolveDiscordMessages deliver failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// jsonContentType is the Content-Type of every JSON response.
+const jsonContentType = "application/json; charset=utf-8"
+
+// writeJSON responds with v as JSON. Responses are never sniffed, so that
+// clients can rely on the declared type.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// writeError responds with the status and its text as a JSON error, such
+// as {"error": "Bad Request"}.
+func writeError(w http.ResponseWriter, status int) {
+	writeJSON(w, status, map[string]string{"error": http.StatusText(status)})
+}
+
+// writeErrorDetail responds like writeError, with detail explaining what
+// was wrong with the request.
+func writeErrorDetail(w http.ResponseWriter, status int, detail string) {
+	writeJSON(w, status, map[string]string{"error": http.StatusText(status), "detail": detail})
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// DeliveryResult is the outcome of a delivery to a destination.
+type DeliveryResult struct {
+	Destination string
+	Success     bool
+	Status      int // HTTP status of the last response, or 0 if there was none
+	Err         error
+	Latency     time.Duration // including any retries
+
+	// request is the HTTP request delivered, or nil for destinations that
+	// aren't reached over HTTP.
+	request *outboundRequest
+}
+
+// deliveryObserver is implemented by the parts of the adapter that act on
+// the outcome of deliveries.
+type deliveryObserver interface {
+	observeDelivery(r DeliveryResult)
+}
+
+// observerFunc adapts a function to the deliveryObserver interface.
+type observerFunc func(r DeliveryResult)
+
+func (f observerFunc) observeDelivery(r DeliveryResult) { f(r) }
+
+// observers reports what every delivery result is passed to, in order. A
+// behavior that applies to all destinations adds itself here, rather than
+// to each sender.
+func observers() []deliveryObserver {
+	return []deliveryObserver{
+		observerFunc(logDelivery),
+		observerFunc(deadLetterDelivery),
+		observerFunc(observeDeliveryMetrics),
+		stats,
+		observerFunc(recordHealth),
+		observerFunc(func(r DeliveryResult) { notifyCallback(cfg.Callback, r) }),
+	}
+}
+
+// reportDelivery passes the result to every observer.
+func reportDelivery(r DeliveryResult) {
+	for _, o := range observers() {
+		o.observeDelivery(r)
+	}
+}
+
+// recordDelivery reports the outcome of a delivery to dest that started at
+// start.
+func recordDelivery(dest string, start time.Time, err error) {
+	reportDelivery(newDeliveryResult(dest, start, err))
+}
+
+func newDeliveryResult(dest string, start time.Time, err error) DeliveryResult {
+	r := DeliveryResult{
+		Destination: dest,
+		Success:     err == nil,
+		Err:         err,
+		Latency:     time.Since(start),
+	}
+	var derr *deliveryError
+	if errors.As(err, &derr) {
+		r.Status = derr.StatusCode
+	}
+	return r
+}
+
+// logDelivery logs successful deliveries at debug level. Failures are
+// logged by the senders, which know what was being done.
+func logDelivery(r DeliveryResult) {
+	if r.Success {
+		debugf("deliver %s succeeded in %v", r.Destination, r.Latency.Round(time.Millisecond))
+	}
+}
+
+// deadLetterDelivery dead-letters HTTP requests that were not delivered.
+func deadLetterDelivery(r DeliveryResult) {
+	if !r.Success && r.request != nil {
+		deadLetter(*r.request, r.Err)
+	}
+}
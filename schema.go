@@ -0,0 +1,87 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// schemaField describes a field of an incoming event, for STRICT_SCHEMA.
+type schemaField struct {
+	name     string
+	kind     string // the JSON type: "string", "number" or "object"
+	required bool
+}
+
+// eventSchema lists the fields of an incoming event, in the order they are
+// checked.
+var eventSchema = []schemaField{
+	{"timestamp", "string", true},
+	{"version", "number", true},
+	{"type", "string", true},
+	{"tailnet", "string", true},
+	{"message", "string", false},
+	{"data", "object", false},
+}
+
+// validateEvents checks the events in b, a JSON array, against
+// eventSchema, reporting the first problem found wrapped in errSchema.
+func validateEvents(b []byte) error {
+	var events []map[string]json.RawMessage
+	if err := json.Unmarshal(b, &events); err != nil {
+		return fmt.Errorf("%w: %v", errSchema, err)
+	}
+	var typed []incomingWebhook
+	for i, e := range events {
+		if e == nil {
+			return fmt.Errorf("%w: event %d is null", errSchema, i)
+		}
+		for _, f := range eventSchema {
+			v, ok := e[f.name]
+			if !ok || string(v) == "null" {
+				if f.required {
+					return fmt.Errorf("%w: event %d has no %q", errSchema, i, f.name)
+				}
+				continue
+			}
+			if kind := jsonKind(v); kind != f.kind {
+				return fmt.Errorf("%w: event %d has %q of type %s, want %s", errSchema, i, f.name, kind, f.kind)
+			}
+		}
+	}
+	// With the types checked, this only fails for data values that are
+	// not strings.
+	if err := json.Unmarshal(b, &typed); err != nil {
+		return fmt.Errorf("%w: %v", errSchema, err)
+	}
+	for i, ev := range typed {
+		if _, err := time.Parse(time.RFC3339, ev.Timestamp); err != nil {
+			return fmt.Errorf("%w: event %d has %q %q, want an RFC 3339 time", errSchema, i, "timestamp", ev.Timestamp)
+		}
+		if ev.Type == "" || ev.Tailnet == "" {
+			return fmt.Errorf("%w: event %d has an empty %q or %q", errSchema, i, "type", "tailnet")
+		}
+	}
+	return nil
+}
+
+// jsonKind reports the JSON type of the value v.
+func jsonKind(v json.RawMessage) string {
+	switch v[0] {
+	case '"':
+		return "string"
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	}
+	return "number"
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// tlsVersions are the TLS_MIN_VERSION values.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion parses a TLS_MIN_VERSION such as "1.2".
+func parseTLSVersion(s string) (uint16, error) {
+	v, ok := tlsVersions[strings.TrimPrefix(strings.TrimSpace(s), "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q, want 1.2 or 1.3", s)
+	}
+	return v, nil
+}
+
+// parseCipherSuites parses TLS_CIPHER_SUITES, a list of cipher suite
+// names such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Only suites that
+// Go considers secure are accepted.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	known := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	var ids []uint16
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// serverTLSConfig reports the TLS configuration of the server, or nil if
+// it serves plain HTTP. TLS 1.3 cipher suites are not configurable, so
+// TLS_CIPHER_SUITES only restricts TLS 1.2 connections.
+func serverTLSConfig(c ServerTLSConfig) *tls.Config {
+	if c.CertFile == "" {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion:   c.MinVersion,
+		CipherSuites: c.CipherSuites,
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// severity classifies how urgently an event needs attention.
+type severity int
+
+const (
+	severityInfo severity = iota
+	severityWarning
+	severityCritical
+)
+
+func (s severity) String() string {
+	switch s {
+	case severityWarning:
+		return "warning"
+	case severityCritical:
+		return "critical"
+	default:
+		return "info"
+	}
+}
+
+// parseSeverity converts a severity name such as "warning" into a severity.
+func parseSeverity(s string) (severity, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "info":
+		return severityInfo, nil
+	case "warning", "warn":
+		return severityWarning, nil
+	case "critical", "crit":
+		return severityCritical, nil
+	}
+	return severityInfo, fmt.Errorf("unknown severity %q", s)
+}
+
+// https://tailscale.com/kb/1213/webhooks/#events
+var eventSeverities = map[string]severity{
+	"test": severityInfo,
+
+	"nodeCreated":             severityInfo,
+	"nodeNeedsApproval":       severityWarning,
+	"nodeApproved":            severityInfo,
+	"nodeKeyExpiringInOneDay": severityWarning,
+	"nodeKeyExpired":          severityCritical,
+	"nodeDeleted":             severityWarning,
+
+	"policyUpdate": severityWarning,
+
+	"userCreated":       severityInfo,
+	"userNeedsApproval": severityWarning,
+	"userSuspended":     severityWarning,
+	"userRestored":      severityInfo,
+	"userDeleted":       severityWarning,
+	"userApproved":      severityInfo,
+	"userRoleUpdated":   severityCritical,
+
+	"subnetIPForwardingNotEnabled":   severityWarning,
+	"exitNodeIPForwardingNotEnabled": severityWarning,
+
+	"webhookUpdated": severityInfo,
+	"webhookDeleted": severityWarning,
+}
+
+// knownEventType reports whether Tailscale documents the event type, or it
+// has been given a severity in SEVERITY_MAP_FILE.
+func knownEventType(eventType string) bool {
+	_, known := eventSeverities[eventType]
+	_, mapped := cfg.Severities[eventType]
+	return known || mapped
+}
+
+// eventSeverity reports the severity of the given event type, from
+// SEVERITY_MAP_FILE or else eventSeverities. Unknown event types are
+// treated as informational.
+func eventSeverity(eventType string) severity {
+	if sev, ok := cfg.Severities[eventType]; ok {
+		return sev
+	}
+	return eventSeverities[eventType]
+}
+
+// readSeverityMap reads a SEVERITY_MAP_FILE, a JSON object mapping event
+// types to severity names, e.g. {"nodeDeleted": "critical"}.
+func readSeverityMap(path string) (map[string]severity, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var names map[string]string
+	if err := json.Unmarshal(b, &names); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	m := make(map[string]severity, len(names))
+	for eventType, name := range names {
+		sev, err := parseSeverity(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, eventType, err)
+		}
+		m[eventType] = sev
+	}
+	return m, nil
+}
+
+// defaultThemeColor is the default DEFAULT_THEME_COLOR, used for events
+// whose severity has no color of its own.
+const defaultThemeColor = "0078D7" // Microsoft blue
+
+var hexColorRE = regexp.MustCompile(`^[0-9A-Fa-f]{6}$`)
+
+// parseThemeColor validates a 6-hex-digit color such as "0078D7".
+// A leading "#" is accepted and removed.
+func parseThemeColor(s string) (string, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if !hexColorRE.MatchString(s) {
+		return "", fmt.Errorf("%q is not a 6-hex-digit color", s)
+	}
+	return strings.ToUpper(s), nil
+}
+
+// severityOf reports the severity of an event. Key expiry warnings become
+// more severe as the expiry nears.
+func severityOf(orig incomingWebhook) severity {
+	sev := eventSeverity(orig.Type)
+	if t, ok := eventExpiry(orig); ok && time.Until(t) < time.Hour && sev < severityCritical {
+		sev = severityCritical
+	}
+	return sev
+}
+
+// themeColor reports the hex color used to highlight an event.
+func themeColor(orig incomingWebhook) string {
+	switch severityOf(orig) {
+	case severityCritical:
+		return "D13438" // red
+	case severityWarning:
+		return "FFB900" // amber
+	}
+	return cfg.DefaultThemeColor
+}
+
+// themeColorInt reports themeColor as an integer, as used by Discord embeds.
+func themeColorInt(orig incomingWebhook) int {
+	c, _ := strconv.ParseInt(themeColor(orig), 16, 32)
+	return int(c)
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// https://bbernhard.github.io/signal-cli-rest-api/#/Messages/post_v2_send
+type signalMessage struct {
+	Message    string   `json:"message"`
+	Number     string   `json:"number"`
+	Recipients []string `json:"recipients"`
+}
+
+// sendSignalMessage sends the event as a text message through a
+// signal-cli REST API server.
+func sendSignalMessage(c SignalConfig, client *http.Client, orig incomingWebhook) error {
+	apiUrl := c.APIURL
+	number := c.Number
+	recipients := c.Recipients
+	if apiUrl == "" || number == "" || len(recipients) == 0 {
+		// not configured
+		return nil
+	}
+
+	data := displayData("signal", orig)
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "%s\n%s", displayLabel("signal", orig), displayMessage("signal", orig))
+	for _, k := range fieldKeys(data) {
+		fmt.Fprintf(buf, "\n%s: %s", fieldLabel(k), data[k])
+	}
+
+	err := deliverParts("signal", messageParts(buf.String(), c.MaxLength), func(part string) (outboundRequest, error) {
+		body, err := json.Marshal(signalMessage{
+			Message:    part,
+			Number:     number,
+			Recipients: recipients,
+		})
+		return outboundRequest{dest: "signal", client: client, url: strings.TrimSuffix(apiUrl, "/") + "/v2/send", body: body}, err
+	})
+	if err != nil {
+		log.Printf("sendSignalMessage deliver failed: %v", err)
+		return err
+	}
+	return nil
+}
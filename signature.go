@@ -7,13 +7,17 @@ package main
 // Copied from https://raw.githubusercontent.com/tailscale/tailscale/main/docs/webhooks/example.go
 
 import (
+	"bytes"
+	"compress/gzip"
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"strconv"
@@ -27,19 +31,73 @@ const (
 )
 
 var (
-	errNotSigned     = errors.New("webhook has no signature")
-	errInvalidHeader = errors.New("webhook has an invalid signature")
+	errNotSigned      = errors.New("webhook has no signature")
+	errInvalidHeader  = errors.New("webhook has an invalid signature")
+	errMissingHeader  = errors.New("request has no signature")
+	errStaleTimestamp = errors.New("invalid header: timestamp older than 5 minutes")
+	errBadSignature   = errors.New("signature does not match")
+	errTooLarge       = errors.New("request body too large")
+	errWrongTailnet   = errors.New("events are not for the tailnet whose secret signed them")
+	errMalformed      = errors.New("malformed events")
+	errSchema         = errors.New("events do not match the schema")
 )
 
-// verifyWebhookSignature checks the request's "Tailscale-Webhook-Signature"
-// header to verify that the events were signed by your webhook secret.
+// maxBodySize bounds the size of an incoming request body.
+const maxBodySize = 1 << 20
+
+// rejectionReasons are the values of rejectionReason, used as metric labels.
+var rejectionReasons = []string{
+	"missing_header", "invalid_header", "bad_version", "stale_timestamp",
+	"bad_signature", "too_large", "wrong_tailnet", "malformed", "invalid_schema", "busy",
+}
+
+// rejectionReason classifies an error from verifyWebhookSignature.
+func rejectionReason(err error) string {
+	switch {
+	case errors.Is(err, errMissingHeader):
+		return "missing_header"
+	case errors.Is(err, errInvalidHeader):
+		return "invalid_header"
+	case errors.Is(err, errNotSigned):
+		// The header had no signatures of a version we understand.
+		return "bad_version"
+	case errors.Is(err, errStaleTimestamp):
+		return "stale_timestamp"
+	case errors.Is(err, errBadSignature):
+		return "bad_signature"
+	case errors.Is(err, errTooLarge):
+		return "too_large"
+	case errors.Is(err, errWrongTailnet):
+		return "wrong_tailnet"
+	case errors.Is(err, errSchema):
+		return "invalid_schema"
+	}
+	return "malformed"
+}
+
+// defaultSignatureHeader is the header Tailscale signs webhooks in.
+const defaultSignatureHeader = "Tailscale-Webhook-Signature"
+
+// signatureHeader reports the request's signature, from the first of the
+// SIGNATURE_HEADER headers that is set.
+func signatureHeader(req *http.Request) string {
+	for _, name := range cfg.SignatureHeaders {
+		if v := req.Header.Get(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// verifyWebhookSignature checks the request's signature header (see
+// signatureHeader) to verify that the events were signed by your webhook secret.
 // If verification fails, an error is reported.
 // If verification succeeds, the list of contained events is reported.
 func verifyWebhookSignature(req *http.Request, secret string) (events []incomingWebhook, err error) {
 	defer req.Body.Close()
 
 	// Grab the signature sent on the request header.
-	timestamp, signatures, err := parseSignatureHeader(req.Header.Get("Tailscale-Webhook-Signature"))
+	timestamp, signatures, err := parseSignatureHeader(signatureHeader(req))
 	if err != nil {
 		return nil, err
 	}
@@ -47,45 +105,181 @@ func verifyWebhookSignature(req *http.Request, secret string) (events []incoming
 	// Verify that the timestamp is recent.
 	// Here, we use a threshold of 5 minutes.
 	if timestamp.Before(time.Now().Add(-time.Minute * 5)) {
-		return nil, fmt.Errorf("invalid header: timestamp older than 5 minutes")
+		return nil, errStaleTimestamp
 	}
 
 	// Form the expected signature.
-	b, err := io.ReadAll(req.Body)
+	b, err := io.ReadAll(io.LimitReader(req.Body, maxBodySize+1))
 	if err != nil {
 		return nil, err
 	}
-	mac := hmac.New(sha256.New, []byte(secret))
+	if len(b) > maxBodySize {
+		return nil, errTooLarge
+	}
+	if err := checkSignature(timestamp, signatures, b, secret); err != nil {
+		return nil, err
+	}
+
+	// If verified, return the events.
+	return decodeEvents(req, b)
+}
+
+// signatureAlgos are the HMAC hashes that SIGNATURE_ALGO can select.
+// Tailscale signs with sha256.
+var signatureAlgos = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// signatureHash reports the hash of the SIGNATURE_ALGO HMAC.
+func signatureHash() func() hash.Hash {
+	if h, ok := signatureAlgos[cfg.SignatureAlgo]; ok {
+		return h
+	}
+	return sha256.New
+}
+
+// computeSignature reports the signature of body, signed with secret at timestamp.
+func computeSignature(timestamp time.Time, body []byte, secret string) string {
+	mac := hmac.New(signatureHash(), []byte(secret))
 	mac.Write([]byte(fmt.Sprint(timestamp.Unix())))
 	mac.Write([]byte("."))
-	mac.Write(b)
-	want := hex.EncodeToString(mac.Sum(nil))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signWebhook signs body with secret like Tailscale does, reporting the
+// value of the Tailscale-Webhook-Signature header that
+// verifyWebhookSignature accepts for it.
+func signWebhook(body []byte, secret string) string {
+	now := time.Now()
+	return fmt.Sprintf("t=%d,%s=%s", now.Unix(), currentVersion, computeSignature(now, body, secret))
+}
+
+// checkSignature reports whether one of the signatures is that of body,
+// signed with secret at timestamp.
+func checkSignature(timestamp time.Time, signatures map[string][]string, body []byte, secret string) error {
+	want := computeSignature(timestamp, body, secret)
 
 	// Verify that the signatures match.
-	var match bool
 	for _, signature := range signatures[currentVersion] {
 		if subtle.ConstantTimeCompare([]byte(signature), []byte(want)) == 1 {
-			match = true
-			break
+			return nil
 		}
 	}
-	if !match {
-		return nil, fmt.Errorf("signature does not match: want = %q, got = %q", want, signatures[currentVersion])
-	}
+	return fmt.Errorf("%w: want = %q, got = %q", errBadSignature, want, signatures[currentVersion])
+}
 
-	// If verified, return the events.
+// decodeEvents decodes the events in a request body. The signature covers
+// the bytes as transmitted, so the body is only decompressed here, once it
+// has been verified.
+//
+// The body must be a JSON array of events and nothing else; an empty,
+// truncated or otherwise malformed body is rejected as a whole, with an
+// error wrapping errMalformed, rather than partially processed.
+func decodeEvents(req *http.Request, b []byte) (events []incomingWebhook, err error) {
+	if strings.EqualFold(req.Header.Get("Content-Encoding"), "gzip") {
+		if b, err = gunzip(b); err != nil {
+			return nil, fmt.Errorf("%w: decompressing body: %v", errMalformed, err)
+		}
+	}
+	b = bytes.TrimSpace(b)
+	switch {
+	case len(b) == 0:
+		return nil, fmt.Errorf("%w: empty body", errMalformed)
+	case b[0] != '[':
+		return nil, fmt.Errorf("%w: body is not a JSON array", errMalformed)
+	}
+	if cfg.StrictSchema {
+		if err := validateEvents(b); err != nil {
+			return nil, err
+		}
+	}
 	if err := json.Unmarshal(b, &events); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", errMalformed, err)
 	}
 	return events, nil
 }
 
+// readUnsignedWebhook decodes the events in a request without checking
+// its signature.
+func readUnsignedWebhook(req *http.Request) ([]incomingWebhook, error) {
+	defer req.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(req.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxBodySize {
+		return nil, errTooLarge
+	}
+	return decodeEvents(req, b)
+}
+
+// Signature modes, set with SIGNATURE_MODE.
+const (
+	// signatureModeTailscale requires every request to be signed.
+	signatureModeTailscale = "tailscale"
+	// signatureModeHeadscale verifies requests that carry a signature,
+	// and accepts unsigned requests, as sent by Headscale deployments
+	// that do not sign webhooks.
+	signatureModeHeadscale = "headscale"
+	// signatureModeNone accepts every request without verification.
+	signatureModeNone = "none"
+)
+
+func parseSignatureMode(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "":
+		return signatureModeTailscale, nil
+	case signatureModeTailscale, signatureModeHeadscale, signatureModeNone:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown signature mode %q, want tailscale, headscale or none", s)
+}
+
+// readWebhook reads the events from an incoming request, verifying its
+// signature as required by the signature mode.
+func readWebhook(req *http.Request, secret string) ([]incomingWebhook, error) {
+	switch cfg.SignatureMode {
+	case signatureModeNone:
+		return readUnsignedWebhook(req)
+	case signatureModeHeadscale:
+		if signatureHeader(req) == "" {
+			return readUnsignedWebhook(req)
+		}
+	}
+	if len(cfg.TailnetSecrets) > 0 {
+		return verifyTailnetSignature(req, cfg.TailnetSecrets, secret)
+	}
+	return verifyWebhookSignature(req, secret)
+}
+
+// maxDecompressedSize bounds how large a gzipped body may expand to.
+const maxDecompressedSize = 10 << 20
+
+// gunzip decompresses a gzip-encoded body.
+func gunzip(b []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed body exceeds %d bytes", maxDecompressedSize)
+	}
+	return out, nil
+}
+
 // parseSignatureHeader splits header into its timestamp and included signatures.
 // The signatures are reported as a map of version (e.g. "v1") to a list of signatures
 // found with that version.
 func parseSignatureHeader(header string) (timestamp time.Time, signatures map[string][]string, err error) {
 	if header == "" {
-		return time.Time{}, nil, fmt.Errorf("request has no signature")
+		return time.Time{}, nil, errMissingHeader
 	}
 
 	signatures = make(map[string][]string)
@@ -93,7 +287,7 @@ func parseSignatureHeader(header string) (timestamp time.Time, signatures map[st
 	for _, pair := range pairs {
 		parts := strings.Split(pair, "=")
 		if len(parts) != 2 {
-			return time.Time{}, nil, errNotSigned
+			return time.Time{}, nil, errInvalidHeader
 		}
 
 		switch parts[0] {
@@ -0,0 +1,133 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// signedRequest makes a webhook request for body, signed with secret.
+func signedRequest(body []byte, secret string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+	req.Header.Set(defaultSignatureHeader, signWebhook(body, secret))
+	return req
+}
+
+func TestGzippedWebhook(t *testing.T) {
+	setTestConfig(t, map[string]string{"TS_WEBHOOK_SECRET": "secret"})
+	plain := []byte(`[{"type":"test","message":"compressed"}]`)
+	compressed, err := gzipBody(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The signature covers the body as sent.
+	req := signedRequest(compressed, "secret")
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handleWebhook(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("gzipped webhook signed as sent: %d %s, want 200", w.Code, w.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(compressed))
+	req.Header.Set(defaultSignatureHeader, signWebhook(plain, "secret"))
+	req.Header.Set("Content-Encoding", "gzip")
+	if _, err := verifyWebhookSignature(req, "secret"); !errors.Is(err, errBadSignature) {
+		t.Errorf("gzipped webhook signed decompressed: %v, want errBadSignature", err)
+	}
+
+	// A small body may not expand beyond maxDecompressedSize.
+	bomb, err := gzipBody([]byte("[" + strings.Repeat(" ", maxDecompressedSize) + "]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bomb) > maxBodySize {
+		t.Fatalf("compressed body is %d bytes, too large for the test", len(bomb))
+	}
+	req = signedRequest(bomb, "secret")
+	req.Header.Set("Content-Encoding", "gzip")
+	if _, err := verifyWebhookSignature(req, "secret"); !errors.Is(err, errMalformed) {
+		t.Errorf("gzipped webhook expanding past maxDecompressedSize: %v, want errMalformed", err)
+	}
+	req = signedRequest(bomb, "secret")
+	req.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	handleWebhook(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("gzipped webhook expanding past maxDecompressedSize: %d, want 400", w.Code)
+	}
+}
+
+func TestDecodeEventsMalformed(t *testing.T) {
+	setTestConfig(t, nil)
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"empty", ""},
+		{"whitespace", " \n\t"},
+		{"object", `{"type":"test","message":"not in an array"}`},
+		{"string", `"events"`},
+		{"truncated", `[{"type":"test","message":"cut off`},
+		{"trailing garbage", `[{"type":"test","message":"ok"}] garbage`},
+		{"two arrays", `[] []`},
+		{"wrong field type", `[{"type":"test","version":"one"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/webhook", nil)
+			events, err := decodeEvents(req, []byte(tt.body))
+			if !errors.Is(err, errMalformed) {
+				t.Errorf("decodeEvents(%q) = %v, %v; want errMalformed", tt.body, events, err)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", nil)
+	events, err := decodeEvents(req, []byte(" [{\"type\":\"test\",\"message\":\"ok\"}]\n"))
+	if err != nil || len(events) != 1 || events[0].Message != "ok" {
+		t.Errorf("decodeEvents of a valid body = %+v, %v", events, err)
+	}
+}
+
+func TestSignatureAlgos(t *testing.T) {
+	body := []byte(`[{"type":"test","message":"signed"}]`)
+	for algo := range signatureAlgos {
+		t.Run(algo, func(t *testing.T) {
+			setTestConfig(t, map[string]string{"SIGNATURE_ALGO": algo})
+			header := signWebhook(body, "secret")
+			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+			req.Header.Set(defaultSignatureHeader, header)
+			if events, err := verifyWebhookSignature(req, "secret"); err != nil || len(events) != 1 {
+				t.Fatalf("verifyWebhookSignature = %v, %v", events, err)
+			}
+
+			req = httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+			req.Header.Set(defaultSignatureHeader, header)
+			if _, err := verifyWebhookSignature(req, "other secret"); !errors.Is(err, errBadSignature) {
+				t.Errorf("verifyWebhookSignature with another secret = %v, want errBadSignature", err)
+			}
+
+			// A signature made with one algorithm fails under the others.
+			for other := range signatureAlgos {
+				if other == algo {
+					continue
+				}
+				cfg.SignatureAlgo = other
+				req = httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+				req.Header.Set(defaultSignatureHeader, header)
+				if _, err := verifyWebhookSignature(req, "secret"); !errors.Is(err, errBadSignature) {
+					t.Errorf("signed with %s, verified with %s: %v, want errBadSignature", algo, other, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slackAPIBaseURL is the base URL of the Slack Web API.
+const slackAPIBaseURL = "https://slack.com/api"
+
+// https://api.slack.com/reference/messaging/attachments
+type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	ThreadTS    string            `json:"thread_ts,omitempty"`
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Title  string       `json:"title"`
+	Text   string       `json:"text,omitempty"`
+	Fields []slackField `json:"fields,omitempty"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// https://api.slack.com/methods/chat.postMessage#examples
+type slackResponse struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+// slackAPIError is an error reported by the Slack Web API, which answers
+// with a 200 status and "ok": false.
+type slackAPIError struct {
+	Method string
+	Code   string // e.g. "channel_not_found"
+}
+
+func (e *slackAPIError) Error() string {
+	return e.Method + " failed: " + e.Code
+}
+
+// checkSlackResponse reports the error in a Web API response, if any, so
+// that it counts as a failed delivery.
+func checkSlackResponse(method string) func(body []byte) error {
+	return func(body []byte) error {
+		var res slackResponse
+		if err := json.Unmarshal(body, &res); err != nil {
+			return fmt.Errorf("%s: decoding the response failed: %w", method, err)
+		}
+		if !res.OK {
+			return &slackAPIError{Method: method, Code: res.Error}
+		}
+		return nil
+	}
+}
+
+// sendSlackMessage posts the event to Slack, through the incoming webhook
+// in SLACK_WEBHOOK_URL or, if that is not set, as the bot identified by
+// SLACK_BOT_TOKEN in the channel SLACK_CHANNEL. Only bot messages can be
+// threaded, as incoming webhooks don't report the messages they post.
+// Events of the types in SLACK_CHANNEL_MAP are posted in the channel they
+// map to instead, which legacy incoming webhooks also allow. With
+// RESOLVE_MESSAGES, bot messages are updated once their events are
+// resolved.
+func sendSlackMessage(c SlackConfig, client *http.Client, orig incomingWebhook) error {
+	if c.WebhookURL == "" && (c.BotToken == "" || c.Channel == "") {
+		// not configured
+		return nil
+	}
+	if c.WebhookURL == "" {
+		resolveSlackMessages(c, client, orig)
+	}
+
+	data := displayData("slack", orig)
+	limit := c.MaxLength
+	attachment := slackAttachment{
+		Color: "#" + themeColor(orig),
+		Title: tailnetTitle(c.IncludeTailnet, orig, displayLabel("slack", orig)),
+		Text:  truncateForLimit(displayMessage("slack", orig), limit),
+	}
+	// Slack shows no language in code blocks.
+	if diff := displayDiff("slack", orig); diff != "" {
+		attachment.Text = appendCodeBlock(attachment.Text, "", truncateForLimit(diff, policyDiffLimit), limit)
+	}
+	if displayRawJSON("slack") {
+		attachment.Text = appendCodeBlock(attachment.Text, "", rawEventJSON(orig), limit)
+	}
+	for _, k := range fieldKeys(data) {
+		attachment.Fields = append(attachment.Fields, slackField{Title: fieldLabel(k), Value: data[k], Short: len(data[k]) < 40})
+	}
+	msg := slackMessage{
+		Text:        truncateForLimit(attachment.Title+": "+displayMessage("slack", orig), limit),
+		Attachments: []slackAttachment{attachment},
+	}
+
+	req := outboundRequest{dest: "slack", client: client, url: c.WebhookURL}
+	msg.Channel = c.ChannelMap[orig.Type]
+	threadKey := ""
+	if c.WebhookURL == "" {
+		if msg.Channel == "" {
+			msg.Channel = c.Channel
+		}
+		req.url = slackAPIBaseURL + "/chat.postMessage"
+		req.header = http.Header{"Authorization": {"Bearer " + c.BotToken}}
+		req.check = checkSlackResponse("chat.postMessage")
+		if c.ThreadKey != "" {
+			if k := strings.TrimSpace(expandPlaceholders(c.ThreadKey, orig)); k != "" {
+				threadKey = orig.Tailnet + "\x00" + msg.Channel + "\x00" + k
+				msg.ThreadTS = slackThreads.get(threadKey, c.ThreadWindow)
+			}
+		}
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("sendSlackMessage json.Marshal failed: %v", err)
+		return err
+	}
+	req.body = body
+
+	resp, err := deliver(req)
+	if err != nil {
+		log.Printf("sendSlackMessage deliver failed: %v", err)
+		return err
+	}
+	if c.WebhookURL != "" || cfg.DryRun {
+		return nil
+	}
+
+	// The response was checked by checkSlackResponse.
+	var res slackResponse
+	json.Unmarshal(resp, &res)
+	if threadKey != "" && msg.ThreadTS == "" {
+		slackThreads.start(threadKey, res.TS)
+	}
+	slackPosted.track(cfg.Resolve, orig, postedSlackMessage{channel: res.Channel, ts: res.TS, msg: msg})
+	return nil
+}
+
+// slackThread is the first message of a group of related events, which
+// the later ones are posted as replies to.
+type slackThread struct {
+	ts      string
+	started time.Time
+}
+
+// slackThreadStore tracks the thread of each group of related events, by
+// the tailnet and SLACK_THREAD_KEY of the events.
+type slackThreadStore struct {
+	mu      sync.Mutex
+	threads map[string]slackThread
+}
+
+var slackThreads = &slackThreadStore{threads: map[string]slackThread{}}
+
+// get reports the ts of the thread for key, or "" if there is none that
+// was started within window.
+func (s *slackThreadStore) get(key string, window time.Duration) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, t := range s.threads {
+		if time.Since(t.started) > window {
+			delete(s.threads, k)
+		}
+	}
+	return s.threads[key].ts
+}
+
+// start records ts as the thread for key.
+func (s *slackThreadStore) start(key, ts string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.threads[key] = slackThread{ts: ts, started: time.Now()}
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// partDelays is how long to wait between the parts of a split message, by
+// destination, to stay clear of the platforms' rate limits. Rate limited
+// parts are retried like any other delivery.
+var partDelays = map[string]time.Duration{
+	"discord":  500 * time.Millisecond, // 5 requests per 2s per webhook
+	"signal":   time.Second,
+	"line":     time.Second,
+	"mastodon": 2 * time.Second,
+}
+
+// messageParts reports the messages to send s in, each of at most limit
+// runes: with SPLIT_LONG_MESSAGES, as many as splitMessage needs, and
+// otherwise s truncated to fit.
+func messageParts(s string, limit int) []string {
+	if !cfg.SplitMessages {
+		return []string{truncateForLimit(s, limit)}
+	}
+	return splitMessage(s, limit, cfg.SplitMaxParts)
+}
+
+// deliverParts delivers the request built for each part of a message in
+// turn, waiting between them as the destination requires, and stops at
+// the first part that fails.
+func deliverParts(dest string, parts []string, build func(part string) (outboundRequest, error)) error {
+	for i, part := range parts {
+		if i > 0 {
+			time.Sleep(partDelays[dest])
+		}
+		req, err := build(part)
+		if err != nil {
+			return err
+		}
+		if _, err := deliver(req); err != nil {
+			return fmt.Errorf("part %d of %d: %w", i+1, len(parts), err)
+		}
+	}
+	return nil
+}
+
+// splitMessage splits s into parts of at most limit runes, each starting
+// with a "(1/3)"-style indicator. It splits between lines where it can, and
+// closes fenced code blocks at the end of a part, reopening them in the
+// next. Beyond maxParts, the last part is truncated.
+func splitMessage(s string, limit, maxParts int) []string {
+	if limit <= 0 || utf8.RuneCountInString(s) <= limit {
+		return []string{s}
+	}
+	const closeFence = "\n```"
+	maxParts = max(maxParts, 1)
+	room := limit - utf8.RuneCountInString(fmt.Sprintf("(%d/%d) ", maxParts, maxParts))
+
+	var parts []string
+	cur := new(strings.Builder)
+	curLen, contentLen := 0, 0 // contentLen leaves out a reopened fence
+	fence := ""                // the opening line of the code block being split
+	reserve := func() int {
+		if fence != "" {
+			return utf8.RuneCountInString(closeFence)
+		}
+		return 0
+	}
+	flush := func() {
+		part := strings.TrimRight(cur.String(), "\n")
+		if fence != "" {
+			part += closeFence
+		}
+		parts = append(parts, part)
+		cur.Reset()
+		curLen, contentLen = 0, 0
+		if fence != "" {
+			cur.WriteString(fence + "\n")
+			curLen = utf8.RuneCountInString(fence) + 1
+		}
+	}
+	for _, line := range strings.SplitAfter(s, "\n") {
+		rest := line
+		for rest != "" {
+			avail := room - curLen - reserve()
+			n := utf8.RuneCountInString(rest)
+			if n <= avail {
+				cur.WriteString(rest)
+				curLen += n
+				contentLen += n
+				break
+			}
+			if contentLen > 0 {
+				flush()
+				continue
+			}
+			// The line doesn't fit in a part of its own: cut it.
+			r := []rune(rest)
+			avail = max(avail, 1)
+			cur.WriteString(string(r[:avail]))
+			curLen += avail
+			contentLen += avail
+			rest = string(r[avail:])
+			flush()
+		}
+		if strings.HasPrefix(line, "```") {
+			if fence == "" {
+				fence = strings.TrimRight(line, "\n")
+			} else {
+				fence = ""
+			}
+		}
+	}
+	if contentLen > 0 {
+		flush()
+	}
+
+	if len(parts) > maxParts {
+		parts = parts[:maxParts]
+		last := parts[maxParts-1]
+		parts[maxParts-1] = truncateForLimit(last, utf8.RuneCountInString(last)-1)
+	}
+	for i, p := range parts {
+		indicator := fmt.Sprintf("(%d/%d)", i+1, len(parts))
+		switch {
+		case strings.HasPrefix(p, "\n"):
+			parts[i] = indicator + p
+		case strings.HasPrefix(p, "```"):
+			parts[i] = indicator + "\n" + p
+		default:
+			parts[i] = indicator + " " + p
+		}
+	}
+	return parts
+}
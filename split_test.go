@@ -0,0 +1,129 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// checkParts checks that parts are numbered as "(i/n)" and fit in limit.
+func checkParts(t *testing.T, parts []string, limit int) {
+	t.Helper()
+	for i, p := range parts {
+		if want := fmt.Sprintf("(%d/%d)", i+1, len(parts)); !strings.HasPrefix(p, want) {
+			t.Errorf("part %d = %q, want it to start with %q", i+1, p, want)
+		}
+		if n := utf8.RuneCountInString(p); n > limit {
+			t.Errorf("part %d is %d runes long, want at most %d", i+1, n, limit)
+		}
+	}
+}
+
+func TestSplitMessageFits(t *testing.T) {
+	for _, limit := range []int{0, 11, 100} {
+		if got := splitMessage("hello world", limit, 10); len(got) != 1 || got[0] != "hello world" {
+			t.Errorf("splitMessage with limit %d = %q, want the message unchanged", limit, got)
+		}
+	}
+}
+
+func TestSplitMessageBetweenLines(t *testing.T) {
+	lines := make([]string, 10)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d of the message", i) // 19 runes
+	}
+	s := strings.Join(lines, "\n")
+	for _, tt := range []struct {
+		limit int
+		parts int
+	}{
+		{60, 5}, // two lines per part
+		{90, 4}, // three lines per part
+		{250, 1},
+	} {
+		parts := splitMessage(s, tt.limit, 10)
+		if len(parts) != tt.parts {
+			t.Errorf("limit %d: split into %d parts, want %d: %q", tt.limit, len(parts), tt.parts, parts)
+			continue
+		}
+		if tt.parts == 1 {
+			continue
+		}
+		checkParts(t, parts, tt.limit)
+		var joined []string
+		for _, p := range parts {
+			_, body, _ := strings.Cut(p, ") ")
+			joined = append(joined, body)
+		}
+		if got := strings.Join(joined, "\n"); got != s {
+			t.Errorf("limit %d: parts join to %q, want the message", tt.limit, got)
+		}
+	}
+}
+
+func TestSplitMessageReopensCodeFence(t *testing.T) {
+	code := make([]string, 8)
+	for i := range code {
+		code[i] = fmt.Sprintf("+ allow rule %d", i)
+	}
+	s := "Policy updated\n```diff\n" + strings.Join(code, "\n") + "\n```\nafter"
+	want := []string{
+		"(1/5) Policy updated\n```diff\n+ allow rule 0\n```",
+		"(2/5)\n```diff\n+ allow rule 1\n+ allow rule 2\n```",
+		"(3/5)\n```diff\n+ allow rule 3\n+ allow rule 4\n```",
+		"(4/5)\n```diff\n+ allow rule 5\n+ allow rule 6\n```",
+		"(5/5)\n```diff\n+ allow rule 7\n```\nafter",
+	}
+	parts := splitMessage(s, 60, 10)
+	checkParts(t, parts, 60)
+	if !slices.Equal(parts, want) {
+		t.Errorf("splitMessage = %q, want %q", parts, want)
+	}
+}
+
+func TestSplitMessageLongLine(t *testing.T) {
+	s := strings.Repeat("é", 250)
+	parts := splitMessage(s, 100, 10)
+	checkParts(t, parts, 100)
+	if len(parts) != 3 {
+		t.Fatalf("split into %d parts, want 3", len(parts))
+	}
+	var got string
+	for _, p := range parts {
+		_, body, _ := strings.Cut(p, ") ")
+		got += body
+	}
+	if got != s {
+		t.Errorf("parts join to %q, want the line", got)
+	}
+}
+
+func TestSplitMessageMaxParts(t *testing.T) {
+	s := strings.Repeat("a line of text\n", 50)
+	parts := splitMessage(s, 40, 3)
+	if len(parts) != 3 {
+		t.Fatalf("split into %d parts, want at most 3", len(parts))
+	}
+	checkParts(t, parts, 40)
+	if last := parts[2]; !strings.HasSuffix(last, truncationMarker) {
+		t.Errorf("last part = %q, want it truncated", last)
+	}
+}
+
+func TestMessageParts(t *testing.T) {
+	s := strings.Repeat("a line of text\n", 5)
+	setTestConfig(t, nil)
+	if got := messageParts(s, 40); len(got) != 1 || utf8.RuneCountInString(got[0]) > 40 {
+		t.Errorf("messageParts without SPLIT_LONG_MESSAGES = %q, want one truncated part", got)
+	}
+	setTestConfig(t, map[string]string{"SPLIT_LONG_MESSAGES": "true"})
+	if got := messageParts(s, 40); len(got) != 3 {
+		t.Errorf("messageParts with SPLIT_LONG_MESSAGES = %q, want 3 parts", got)
+	}
+}
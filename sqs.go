@@ -0,0 +1,95 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+var (
+	sqsClientOnce sync.Once
+	sqsClient     *sqs.Client
+	sqsClientErr  error
+)
+
+// getSQSClient creates the SQS client on first use, with credentials and
+// region from the AWS SDK's default chain (environment, shared config,
+// instance or task role). It sends its requests through httpClient, so that
+// OUTBOUND_CA_FILE and ALLOWED_DESTINATION_HOSTS apply to them; fetching
+// credentials does not.
+func getSQSClient() (*sqs.Client, error) {
+	sqsClientOnce.Do(func() {
+		cfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			sqsClientErr = err
+			return
+		}
+		sqsClient = sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+			o.HTTPClient = httpClient
+		})
+	})
+	return sqsClient, sqsClientErr
+}
+
+// sendSQSMessage enqueues the event as JSON on the queue in SQS_QUEUE_URL.
+// For FIFO queues, events are grouped by tailnet so that each tailnet's
+// events are consumed in order, and deduplicated by their content.
+func sendSQSMessage(c SQSConfig, orig incomingWebhook) error {
+	queueUrl := c.QueueURL
+	if queueUrl == "" {
+		// not configured
+		return nil
+	}
+
+	orig.Data = filterData(orig.Data)
+	body, err := json.Marshal(orig)
+	if err != nil {
+		log.Printf("sendSQSMessage json.Marshal failed: %v", err)
+		return err
+	}
+	observePayload("sqs", body)
+
+	if cfg.DryRun {
+		log.Printf("sendSQSMessage (dry run): %s", body)
+		return nil
+	}
+
+	start := time.Now()
+	client, err := getSQSClient()
+	if err != nil {
+		recordDelivery("sqs", start, err)
+		log.Printf("sendSQSMessage loading AWS config failed: %v", err)
+		return err
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueUrl),
+		MessageBody: aws.String(string(body)),
+	}
+	if strings.HasSuffix(queueUrl, ".fifo") {
+		input.MessageGroupId = aws.String(orig.Tailnet)
+		input.MessageDeduplicationId = aws.String(eventHash(orig))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := client.SendMessage(ctx, input)
+	recordDelivery("sqs", start, err)
+	if err != nil {
+		log.Printf("sendSQSMessage SendMessage failed: %v", err)
+		return err
+	}
+	debugf("sendSQSMessage sent message %s", aws.ToString(out.MessageId))
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// warmedUp is closed once STARTUP_DELAY has passed. It is nil when there
+// is no delay to wait for.
+var warmedUp chan struct{}
+
+// startWarmUp holds back deliveries for the STARTUP_DELAY after start,
+// giving the network of a freshly started container time to come up.
+// Webhooks are still accepted in the meantime.
+func startWarmUp(delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+	log.Printf("Holding deliveries for STARTUP_DELAY=%v", delay)
+	warmedUp = make(chan struct{})
+	time.AfterFunc(delay, func() {
+		close(warmedUp)
+		debugf("startWarmUp: STARTUP_DELAY elapsed")
+	})
+}
+
+// warmingUp reports whether deliveries are still being held back.
+func warmingUp() bool {
+	if warmedUp == nil {
+		return false
+	}
+	select {
+	case <-warmedUp:
+		return false
+	default:
+		return true
+	}
+}
+
+// waitWarmUp blocks until STARTUP_DELAY has passed.
+func waitWarmUp() {
+	if warmedUp != nil {
+		<-warmedUp
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// adapterStats is a lightweight, human-readable summary of the adapter's
+// activity, served at /stats for deployments without Prometheus.
+type adapterStats struct {
+	mu             sync.Mutex
+	started        time.Time
+	eventsReceived int64
+	lastEvent      time.Time
+	destinations   map[string]*destinationStats
+}
+
+type destinationStats struct {
+	Sent   int64 `json:"sent"`
+	Failed int64 `json:"failed"`
+}
+
+var stats = &adapterStats{
+	started:      time.Now(),
+	destinations: map[string]*destinationStats{},
+}
+
+// recordEvents records the receipt of n events.
+func (s *adapterStats) recordEvents(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.eventsReceived += int64(n)
+	s.lastEvent = time.Now()
+}
+
+// observeDelivery counts a delivery as sent or failed.
+func (s *adapterStats) observeDelivery(r DeliveryResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.destinations[r.Destination]
+	if d == nil {
+		d = new(destinationStats)
+		s.destinations[r.Destination] = d
+	}
+	if r.Success {
+		d.Sent++
+	} else {
+		d.Failed++
+	}
+}
+
+type statsSnapshot struct {
+	Started        time.Time                   `json:"started"`
+	Uptime         string                      `json:"uptime"`
+	EventsReceived int64                       `json:"eventsReceived"`
+	LastEvent      time.Time                   `json:"lastEvent,omitzero"`
+	Destinations   map[string]destinationStats `json:"destinations"`
+}
+
+func (s *adapterStats) snapshot() statsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := statsSnapshot{
+		Started:        s.started,
+		Uptime:         time.Since(s.started).Round(time.Second).String(),
+		EventsReceived: s.eventsReceived,
+		LastEvent:      s.lastEvent,
+		Destinations:   make(map[string]destinationStats, len(s.destinations)),
+	}
+	for dest, d := range s.destinations {
+		snap.Destinations[dest] = *d
+	}
+	return snap
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, stats.snapshot())
+}
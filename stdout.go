@@ -0,0 +1,41 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"time"
+)
+
+// eventLogger writes events as structured records to stdout, separate
+// from the operational logs on stderr, for collection by a log shipper.
+var eventLogger = slog.New(slog.NewJSONHandler(os.Stdout, nil)).With("logger", "events")
+
+// sendStdoutEvent emits the event on stdout if STDOUT_EVENTS is set.
+func sendStdoutEvent(c StdoutConfig, orig incomingWebhook) error {
+	if !c.Enabled {
+		// not configured
+		return nil
+	}
+
+	// Always one record per line, for the log shippers reading stdout,
+	// so PRETTY_JSON doesn't apply.
+	data := filterData(orig.Data)
+	attrs := make([]any, 0, len(data))
+	for _, k := range fieldKeys(data) {
+		attrs = append(attrs, slog.String(k, data[k]))
+	}
+	start := time.Now()
+	eventLogger.Info(orig.Message,
+		slog.String("timestamp", orig.Timestamp),
+		slog.Int("version", orig.Version),
+		slog.String("type", orig.Type),
+		slog.String("tailnet", orig.Tailnet),
+		slog.Group("data", attrs...),
+	)
+	recordDelivery("stdout", start, nil)
+	return nil
+}
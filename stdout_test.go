@@ -0,0 +1,63 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSendStdoutEventIsOneRecordPerLine(t *testing.T) {
+	for _, pretty := range []string{"false", "true"} {
+		t.Run("PRETTY_JSON="+pretty, func(t *testing.T) {
+			c := setTestConfig(t, map[string]string{
+				"STDOUT_EVENTS": "true",
+				"PRETTY_JSON":   pretty,
+				"INSTANCE_NAME": "eu-1",
+			})
+			buf := new(bytes.Buffer)
+			old := eventLogger
+			eventLogger = slog.New(slog.NewJSONHandler(buf, nil)).With("logger", "events")
+			prefix, flags := log.Prefix(), log.Flags()
+			t.Cleanup(func() {
+				eventLogger = old
+				log.SetPrefix(prefix)
+				log.SetFlags(flags)
+			})
+			configureLogging(c)
+
+			err := sendStdoutEvent(c.Stdout, incomingWebhook{
+				Type:    "nodeCreated",
+				Tailnet: "example.com",
+				Message: "Node created",
+				Data:    map[string]string{"nodeID": "n1"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			if len(lines) != 1 {
+				t.Fatalf("wrote %d lines, want 1:\n%s", len(lines), buf)
+			}
+			var rec struct {
+				Logger   string            `json:"logger"`
+				Instance string            `json:"instance"`
+				Msg      string            `json:"msg"`
+				Type     string            `json:"type"`
+				Data     map[string]string `json:"data"`
+			}
+			if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Logger != "events" || rec.Instance != "eu-1" || rec.Msg != "Node created" || rec.Type != "nodeCreated" || rec.Data["nodeID"] != "n1" {
+				t.Errorf("record = %+v", rec)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"text/template"
+)
+
+// payloadTemplates caches parsed payload templates by file name.
+var payloadTemplates sync.Map // string => *template.Template
+
+var payloadTemplateFuncs = template.FuncMap{
+	// json renders a value as a JSON literal, so that strings from the
+	// event are safely quoted and escaped, e.g. {"text": {{json .Message}}}.
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// loadPayloadTemplate parses the text/template in the named file,
+// caching the result for subsequent calls.
+func loadPayloadTemplate(filename string) (*template.Template, error) {
+	if t, ok := payloadTemplates.Load(filename); ok {
+		return t.(*template.Template), nil
+	}
+	// ParseFiles names the template after the file's base name.
+	t, err := template.New(filepath.Base(filename)).Funcs(payloadTemplateFuncs).ParseFiles(filename)
+	if err != nil {
+		return nil, err
+	}
+	payloadTemplates.Store(filename, t)
+	return t, nil
+}
+
+// renderPayloadTemplate renders the template in the named file with data,
+// usually the event, and checks that the result is valid JSON.
+func renderPayloadTemplate(filename string, data any) ([]byte, error) {
+	t, err := loadPayloadTemplate(filename)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template %s did not render valid JSON", filename)
+	}
+	return buf.Bytes(), nil
+}
+
+// checkPayloadTemplate renders the template in the named file with
+// sample data, so that a broken template is caught at startup rather than
+// with the first event. An empty filename is not checked.
+func checkPayloadTemplate(filename string, sample any) error {
+	if filename == "" {
+		return nil
+	}
+	_, err := renderPayloadTemplate(filename, sample)
+	return err
+}
+
+// sampleEvent is the event templates are checked with.
+var sampleEvent = incomingWebhook{
+	Timestamp: "2024-01-02T15:04:05Z",
+	Version:   1,
+	Type:      "nodeCreated",
+	Tailnet:   "example.com",
+	Message:   "Node laptop created",
+	Data:      map[string]string{"nodeID": "n12345", "deviceName": "laptop", "actor": "alice@example.com"},
+}
+
+var placeholderRE = regexp.MustCompile(`\{([A-Za-z0-9_.-]+)\}`)
+
+// expandPlaceholders replaces {name} placeholders in s with values from
+// the event: {type}, {label}, {message}, {tailnet}, {device} and {user},
+// or any key of the event's data such as {nodeID}. The data is filtered
+// first, and placeholders for fields that are redacted, left out or
+// missing expand to "".
+func expandPlaceholders(s string, orig incomingWebhook) string {
+	orig.Data = filterData(orig.Data)
+	return placeholderRE.ReplaceAllStringFunc(s, func(m string) string {
+		var v string
+		switch name := m[1 : len(m)-1]; name {
+		case "type":
+			return orig.Type
+		case "label":
+			return eventLabel(orig.Type)
+		case "message":
+			return orig.Message
+		case "tailnet":
+			return orig.Tailnet
+		case "device":
+			v = eventDevice(orig)
+		case "user":
+			v = eventUser(orig)
+		default:
+			v = orig.Data[name]
+		}
+		if v == redactedValue {
+			return ""
+		}
+		return v
+	})
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExpandPlaceholders(t *testing.T) {
+	orig := incomingWebhook{
+		Type:    "userRoleUpdated",
+		Tailnet: "example.com",
+		Message: "Role updated",
+		Data:    map[string]string{"nodeID": "n123", "deviceName": "laptop", "loginName": "bob@example.com", "actor": "alice@example.com"},
+	}
+	for _, tt := range []struct {
+		tmpl string
+		env  map[string]string
+		want string
+	}{
+		{"{type} in {tailnet}: {message}", nil, "userRoleUpdated in example.com: Role updated"},
+		{"{device}/{nodeID}", nil, "laptop/n123"},
+		{"{user}", nil, "bob@example.com"},
+		{"{user}", map[string]string{"DROP_FIELDS": "loginName"}, ""},
+		{"{device}", map[string]string{"DROP_FIELDS": "deviceName"}, "n123"},
+		{"[{device}] {nodeID}", map[string]string{"REDACT_FIELDS": "deviceName,nodeID"}, "[] "},
+		{"{actor} {nodeID}", map[string]string{"INCLUDE_FIELDS": "nodeID"}, " n123"},
+		{"{missing}", nil, ""},
+	} {
+		setTestConfig(t, tt.env)
+		if got := expandPlaceholders(tt.tmpl, orig); got != tt.want {
+			t.Errorf("%v: expandPlaceholders(%q) = %q, want %q", tt.env, tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestSlackThreadKeyIgnoresRedactedDevice(t *testing.T) {
+	c := setTestConfig(t, map[string]string{
+		"SLACK_BOT_TOKEN":  "xoxb-token",
+		"SLACK_CHANNEL":    "C123",
+		"SLACK_THREAD_KEY": "{device}",
+		"REDACT_FIELDS":    "deviceName,nodeID",
+	})
+	stub := newStubDestination(t, `{"ok":true,"channel":"C123","ts":"1.2"}`)
+	for range 2 {
+		if err := sendSlackMessage(c.Slack, stub.client, testEvent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var msg struct {
+		ThreadTS string `json:"thread_ts"`
+	}
+	reply := stub.sent()[1]
+	if err := json.Unmarshal(reply.Body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ThreadTS != "" {
+		t.Errorf("thread_ts = %q, want events of redacted devices not to be threaded", msg.ThreadTS)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// verifyTailnetSignature is like verifyWebhookSignature, for adapters
+// shared by several tailnets that each sign their webhooks with their own
+// secret, from TAILNET_SECRETS.
+//
+// The secret of the tailnet named by the "tailnet" query parameter, or
+// else by the (not yet verified) events, is tried first, then those of
+// the other tailnets, and finally fallback, which is TS_WEBHOOK_SECRET.
+// Events signed with a tailnet's secret must all be for that tailnet, so
+// that one tenant can't post events as another.
+func verifyTailnetSignature(req *http.Request, secrets map[string]string, fallback string) ([]incomingWebhook, error) {
+	defer req.Body.Close()
+
+	timestamp, signatures, err := parseSignatureHeader(signatureHeader(req))
+	if err != nil {
+		return nil, err
+	}
+	if timestamp.Before(time.Now().Add(-time.Minute * 5)) {
+		return nil, errStaleTimestamp
+	}
+	b, err := io.ReadAll(io.LimitReader(req.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxBodySize {
+		return nil, errTooLarge
+	}
+
+	hint := req.URL.Query().Get("tailnet")
+	if hint == "" {
+		if events, err := decodeEvents(req, b); err == nil && len(events) > 0 {
+			hint = events[0].Tailnet
+		}
+	}
+	tailnets := sortedKeys(secrets)
+	if _, ok := secrets[hint]; ok {
+		i := slices.Index(tailnets, hint)
+		tailnets = append([]string{hint}, slices.Delete(tailnets, i, i+1)...)
+	}
+
+	for _, tailnet := range tailnets {
+		if err := checkSignature(timestamp, signatures, b, secrets[tailnet]); err != nil {
+			continue
+		}
+		events, err := decodeEvents(req, b)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range events {
+			if e.Tailnet != tailnet {
+				return nil, errWrongTailnet
+			}
+		}
+		return events, nil
+	}
+	if fallback != "" {
+		if err := checkSignature(timestamp, signatures, b, fallback); err == nil {
+			return decodeEvents(req, b)
+		}
+	}
+	return nil, fmt.Errorf("%w with any secret", errBadSignature)
+}
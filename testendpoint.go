@@ -0,0 +1,71 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	testMu   sync.Mutex
+	lastTest time.Time
+)
+
+// testResult is the response to a test notification.
+type testResult struct {
+	Destinations map[string]string `json:"destinations"` // latency, by destination
+}
+
+// handleTest sends a test event to every configured destination, so that
+// the setup can be checked without waiting for a real event. As it posts
+// to real channels, it is disabled unless TEST_TOKEN is set, requires
+// that token, and accepts at most one request per TEST_RATE_LIMIT.
+func handleTest(w http.ResponseWriter, r *http.Request) {
+	if cfg.TestToken == "" {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.TestToken)) != 1 {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+
+	testMu.Lock()
+	if wait := cfg.TestRateLimit - time.Since(lastTest); wait > 0 {
+		testMu.Unlock()
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeError(w, http.StatusTooManyRequests)
+		return
+	}
+	lastTest = time.Now()
+	testMu.Unlock()
+
+	log.Printf("handleTest sending test event")
+	event := incomingWebhook{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Version:   1,
+		Type:      "test",
+		Message:   "This is a test event from ts-webhook-adapter",
+	}
+	res := testResult{Destinations: map[string]string{}}
+	latencies, _ := deliverNow(event)
+	for dest, d := range latencies {
+		res.Destinations[dest] = d.Round(time.Millisecond).String()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
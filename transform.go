@@ -0,0 +1,112 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// TransformConfig holds the TRANSFORM_* settings.
+type TransformConfig struct {
+	Command  []string // TRANSFORM_CMD, split on spaces
+	Timeout  time.Duration
+	MaxBytes int
+}
+
+// errTransformOutputTooLarge is reported for commands whose output
+// exceeds TRANSFORM_MAX_BYTES.
+var errTransformOutputTooLarge = errors.New("output exceeds TRANSFORM_MAX_BYTES")
+
+// limitedBuffer collects up to max bytes, failing writes beyond that, and
+// calling onExceed, or, if truncate is set, discarding them.
+type limitedBuffer struct {
+	buf      bytes.Buffer // not embedded, so that its ReadFrom can't bypass Write
+	max      int
+	truncate bool
+	onExceed func()
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.buf.Len()+len(p) > b.max {
+		b.exceeded = true
+		if b.truncate {
+			b.buf.Write(p[:b.max-b.buf.Len()])
+			return len(p), nil
+		}
+		b.onExceed()
+		return 0, errTransformOutputTooLarge
+	}
+	return b.buf.Write(p)
+}
+
+// transformEvent pipes the event as JSON through TRANSFORM_CMD, and
+// reports the event the command writes to its standard output, or false
+// if it writes nothing, to drop the event. If the command fails, times out
+// or writes something other than an event, the event is used as is.
+func transformEvent(c TransformConfig, orig incomingWebhook) (incomingWebhook, bool) {
+	if len(c.Command) == 0 {
+		// not configured
+		return orig, true
+	}
+	in, err := json.Marshal(orig)
+	if err != nil {
+		log.Printf("transformEvent json.Marshal failed: %v", err)
+		return orig, true
+	}
+	out, err := runTransform(c, in)
+	if err != nil {
+		log.Printf("transformEvent %s failed, using the %s event as is: %v", c.Command[0], orig.Type, err)
+		return orig, true
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		debugf("transformEvent dropped %s event", orig.Type)
+		return orig, false
+	}
+	var transformed incomingWebhook
+	if err := json.Unmarshal(out, &transformed); err != nil {
+		log.Printf("transformEvent %s wrote an invalid event, using the %s event as is: %v", c.Command[0], orig.Type, err)
+		return orig, true
+	}
+	return transformed, true
+}
+
+// runTransform runs the command with in as its standard input, and
+// reports its standard output.
+func runTransform(c TransformConfig, in []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.Command[0], c.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(in)
+	// Don't wait for its output for long once the command has been
+	// stopped, in case it left children behind that hold onto it.
+	cmd.WaitDelay = 100 * time.Millisecond
+	stdout := &limitedBuffer{max: c.MaxBytes, onExceed: cancel} // stops the command
+	stderr := &limitedBuffer{max: 4 << 10, truncate: true}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if stdout.exceeded {
+		return nil, errTransformOutputTooLarge
+	}
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("timed out after %v", c.Timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.buf.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.buf.Bytes(), nil
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Platform message length limits, in characters.
+const (
+	discordContentLimit     = 2000
+	discordDescriptionLimit = 4096 // of an embed
+	signalMessageLimit      = 2000
+	lineMessageLimit        = 5000
+	slackTextLimit          = 4000 // of a message or attachment, as Slack recommends
+	mastodonStatusLimit     = 500
+)
+
+const truncationMarker = "\n...\n"
+
+// truncateForLimit shortens s to at most limit runes, replacing the end
+// with a marker when it had to be cut.
+func truncateForLimit(s string, limit int) string {
+	if limit <= 0 || utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	r := []rune(s)
+	keep := limit - utf8.RuneCountInString(truncationMarker)
+	if keep <= 0 {
+		return string(r[:limit])
+	}
+	return strings.TrimRight(string(r[:keep]), " \n") + truncationMarker
+}
+
+// appendCodeBlock appends code to s as a fenced code block, truncating
+// the code so that the result stays within limit runes. Since the block
+// is the least important part of a message, it is left out entirely if
+// too little room remains.
+func appendCodeBlock(s, lang, code string, limit int) string {
+	const minCode = 40
+	open, close := "\n```"+lang+"\n", "\n```"
+	if limit <= 0 {
+		return s + open + code + close
+	}
+	avail := limit - utf8.RuneCountInString(s) - utf8.RuneCountInString(open) - utf8.RuneCountInString(close)
+	if avail < minCode {
+		return s
+	}
+	return s + open + truncateForLimit(code, avail) + close
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateForLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		limit int
+		want  string
+	}{
+		{"short", "hello", 10, "hello"},
+		{"exact limit", "hello", 5, "hello"},
+		{"one over", "hello world", 10, "hello" + truncationMarker},
+		{"trailing space trimmed", "hello   world!", 13, "hello" + truncationMarker},
+		{"multibyte at the cut", "ééééééééé", 8, "ééé" + truncationMarker},
+		{"emoji at the cut", strings.Repeat("🔑", 10), 7, "🔑🔑" + truncationMarker},
+		{"exact limit multibyte", "日本語", 3, "日本語"},
+		{"limit 0", "hello world", 0, "hello world"},
+		{"negative limit", "hello world", -1, "hello world"},
+		{"limit smaller than the marker", "hello world", 3, "hel"},
+		{"limit equal to the marker", "hello world", utf8.RuneCountInString(truncationMarker), "hello"},
+		{"limit smaller than the marker multibyte", "ééééé", 2, "éé"},
+		{"empty", "", 5, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateForLimit(tt.s, tt.limit)
+			if got != tt.want {
+				t.Errorf("truncateForLimit(%q, %d) = %q, want %q", tt.s, tt.limit, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncateForLimit(%q, %d) = %q is not valid UTF-8", tt.s, tt.limit, got)
+			}
+			if tt.limit > 0 && utf8.RuneCountInString(got) > tt.limit {
+				t.Errorf("truncateForLimit(%q, %d) is %d runes long", tt.s, tt.limit, utf8.RuneCountInString(got))
+			}
+		})
+	}
+}
+
+func TestPlatformLimits(t *testing.T) {
+	long := strings.Repeat("ü", 10000)
+	for name, limit := range map[string]int{
+		"discord":          discordContentLimit,
+		"discord embed":    discordDescriptionLimit,
+		"signal":           signalMessageLimit,
+		"line":             lineMessageLimit,
+		"mastodon":         mastodonStatusLimit,
+		"slack":            slackTextLimit,
+		"policy diff":      policyDiffLimit,
+		"limit is ignored": 0,
+	} {
+		got := truncateForLimit(long, limit)
+		n := utf8.RuneCountInString(got)
+		switch {
+		case limit == 0 && got != long:
+			t.Errorf("%s: truncated to %d runes", name, n)
+		case limit > 0 && (n != limit || !strings.HasSuffix(got, truncationMarker)):
+			t.Errorf("%s: truncated to %d runes, want %d ending with the marker", name, n, limit)
+		}
+	}
+}
+
+func TestAppendCodeBlock(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     string
+		code  string
+		limit int
+		want  string
+	}{
+		{"fits", "msg", "code", 100, "msg\n```json\ncode\n```"},
+		{"no limit", "msg", "code", 0, "msg\n```json\ncode\n```"},
+		{"code truncated", "msg", strings.Repeat("x", 100), 80, "msg\n```json\n" + strings.Repeat("x", 59) + truncationMarker + "\n```"},
+		{"no room", strings.Repeat("m", 70), "code", 100, strings.Repeat("m", 70)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := appendCodeBlock(tt.s, "json", tt.code, tt.limit)
+			if got != tt.want {
+				t.Errorf("appendCodeBlock = %q, want %q", got, tt.want)
+			}
+			if tt.limit > 0 && utf8.RuneCountInString(got) > tt.limit {
+				t.Errorf("appendCodeBlock is %d runes long, want at most %d", utf8.RuneCountInString(got), tt.limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recentEvent is an event shown at /ui, with the outcome of its delivery
+// to each destination.
+type recentEvent struct {
+	Delivered time.Time
+	Type      string
+	Tailnet   string
+	Message   string
+	Severity  string
+	Results   []recentResult
+
+	hash string // eventHash, to match the results of the same event
+}
+
+type recentResult struct {
+	Destination string
+	Latency     time.Duration
+	Error       string // "" if delivered
+}
+
+// recentEvents is a ring buffer of the last UI_EVENTS events delivered.
+type recentEvents struct {
+	mu     sync.Mutex
+	events []recentEvent
+	next   int // where the next event goes, once events is full
+}
+
+var recent = &recentEvents{}
+
+// add records the result of delivering the event to a destination. It
+// joins the results of the same event, which arrive one by one and, with
+// QUEUE_MODE=per-destination, from different workers, to the most recent
+// entry of the event without a result for that destination. Beyond max
+// entries, the oldest one is replaced. It is a no-op unless the UI is
+// enabled.
+func (r *recentEvents) add(event incomingWebhook, res recentResult, max int) {
+	if cfg.UIToken == "" || max <= 0 {
+		return
+	}
+	hash := eventHash(event)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.events {
+		e := &r.events[(r.next+len(r.events)-1-i)%len(r.events)]
+		if e.hash == hash && !slices.ContainsFunc(e.Results, func(r recentResult) bool { return r.Destination == res.Destination }) {
+			e.Results = append(e.Results, res)
+			slices.SortFunc(e.Results, func(a, b recentResult) int { return strings.Compare(a.Destination, b.Destination) })
+			return
+		}
+	}
+	e := recentEvent{
+		Delivered: time.Now(),
+		Type:      event.Type,
+		Tailnet:   event.Tailnet,
+		Message:   event.Message,
+		Severity:  severityOf(event).String(),
+		Results:   []recentResult{res},
+		hash:      hash,
+	}
+	if len(r.events) < max {
+		r.events = append(r.events, e)
+		return
+	}
+	r.events[r.next] = e
+	r.next = (r.next + 1) % len(r.events)
+}
+
+// list reports the recorded events, most recent first.
+func (r *recentEvents) list() []recentEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]recentEvent, 0, len(r.events))
+	for i := range r.events {
+		e := r.events[(r.next+len(r.events)-1-i)%len(r.events)]
+		e.Results = slices.Clone(e.Results)
+		list = append(list, e)
+	}
+	return list
+}
+
+// recordRecent records the delivery of the event to dest for /ui, with
+// its latency and error, as reported by sendTo.
+func recordRecent(event incomingWebhook, dest string, latency time.Duration, err error) {
+	res := recentResult{Destination: dest, Latency: latency.Round(time.Millisecond)}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	recent.add(event, res, cfg.UIEvents)
+}
+
+var uiTemplate = template.Must(template.New("ui").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>ts-webhook-adapter</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 1.5em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: .4em; text-align: left; vertical-align: top; }
+.critical { color: #D13438; } .warning { color: #C19C00; }
+.ok { color: #107C10; } .failed { color: #D13438; }
+</style>
+</head>
+<body>
+<h1>Recent events</h1>
+<p>The last {{len .Events}} events delivered, most recent first, since {{.Started.Format "2006-01-02 15:04:05 MST"}}.</p>
+<table>
+<tr><th>Delivered</th><th>Type</th><th>Tailnet</th><th>Message</th><th>Destinations</th></tr>
+{{range .Events}}<tr>
+<td>{{.Delivered.Format "2006-01-02 15:04:05"}}</td>
+<td class="{{.Severity}}">{{.Type}}</td>
+<td>{{.Tailnet}}</td>
+<td>{{.Message}}</td>
+<td>{{range .Results}}{{if .Error}}<span class="failed" title="{{.Error}}">✗ {{.Destination}}</span>{{else}}<span class="ok">✓ {{.Destination}}</span>{{end}} ({{.Latency}})<br>{{end}}</td>
+</tr>
+{{else}}<tr><td colspan="5">No events yet.</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// handleUI serves an HTML page of the last UI_EVENTS events delivered and
+// the outcome for each destination. It is disabled unless UI_TOKEN is set,
+// and requires that token, as a bearer token or, for browsers, in the
+// token query parameter.
+func handleUI(w http.ResponseWriter, r *http.Request) {
+	if cfg.UIToken == "" {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.UIToken)) != 1 {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	w.Header().Set("Referrer-Policy", "no-referrer") // the URL may hold the token
+	w.Header().Set("Cache-Control", "no-store")
+	err := uiTemplate.Execute(w, struct {
+		Started time.Time
+		Events  []recentEvent
+	}{stats.started, recent.list()})
+	if err != nil {
+		log.Printf("handleUI executing template failed: %v", err)
+	}
+}
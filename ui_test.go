@@ -0,0 +1,60 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUIRecordsQueuedDeliveries(t *testing.T) {
+	for _, mode := range []string{queueModeShared, queueModePerDestination, queueModePerTailnet} {
+		t.Run(mode, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/slack" {
+					writeError(w, http.StatusBadRequest)
+				}
+			}))
+			defer srv.Close()
+			c := setTestConfig(t, map[string]string{
+				"GENERIC_WEBHOOK_URL": srv.URL + "/generic",
+				"SLACK_WEBHOOK_URL":   srv.URL + "/slack",
+				"QUEUE_MODE":          mode,
+				"RETRY_MAX_ATTEMPTS":  "1",
+				"UI_TOKEN":            "token",
+			})
+			old := recent
+			recent = &recentEvents{}
+			t.Cleanup(func() { recent = old })
+
+			startQueue(c.Queue)
+			deliverEvent(incomingWebhook{Type: "nodeCreated", Tailnet: "example.com", Message: "Node created"})
+			queue.Close()
+			queue = nil
+
+			events := recent.list()
+			if len(events) != 1 {
+				t.Fatalf("recorded %d events, want 1: %+v", len(events), events)
+			}
+			res := events[0].Results
+			if len(res) != 2 || res[0].Destination != "generic" || res[0].Error != "" || res[1].Destination != "slack" || res[1].Error == "" {
+				t.Errorf("results = %+v, want generic delivered and slack failed", res)
+			}
+
+			w := httptest.NewRecorder()
+			handleUI(w, httptest.NewRequest(http.MethodGet, "/ui?token=token", nil))
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Node created") {
+				t.Errorf("GET /ui = %d:\n%s", w.Code, w.Body)
+			}
+			w = httptest.NewRecorder()
+			handleUI(w, httptest.NewRequest(http.MethodGet, "/ui?token=wrong", nil))
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("GET /ui with the wrong token = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+	"sync"
+)
+
+// Set at build time with:
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+// getBuildInfo reports the version information embedded with -ldflags,
+// falling back to the module and VCS information recorded by the Go
+// toolchain for any values that were not set. It is worked out once, as it
+// is needed for the User-Agent of every outgoing request.
+var getBuildInfo = sync.OnceValue(func() buildInfo {
+	info := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.GoVersion = bi.GoVersion
+		if info.Version == "" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = s.Value
+				}
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "(devel)"
+	}
+	return info
+})
+
+// userAgent reports the User-Agent sent with outgoing requests, which can
+// be overridden with HTTP_USER_AGENT.
+func userAgent() string {
+	if ua := cfg.UserAgent; ua != "" {
+		return ua
+	}
+	return "ts-webhook-adapter/" + getBuildInfo().Version
+}
+
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, getBuildInfo())
+}